@@ -0,0 +1,68 @@
+package smbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Reads count consecutive registers starting at start and returns them as
+// a JSON object mapping each register to its value. Registers are keyed by
+// their name in names, or by their hex address, such as "0x1A", if they
+// have none. The registers are read under the bus lock.
+func (smb *SMBus) ReadBankJSON(start byte, count int, names map[byte]string) ([]byte, error) {
+	if err := checkRange(start, count); err != nil {
+		return nil, err
+	}
+	values, err := smb.readRange(start, count)
+	if err != nil {
+		return nil, err
+	}
+	bank := make(map[string]byte, count)
+	for i, val := range values {
+		cmd := start + byte(i)
+		key, ok := names[cmd]
+		if !ok {
+			key = fmt.Sprintf("0x%02X", cmd)
+		}
+		bank[key] = val
+	}
+	return json.Marshal(bank)
+}
+
+// Parses a JSON object as produced by ReadBankJSON and writes every value
+// to its register, in ascending register order and under the bus lock.
+// Keys are looked up in names first and otherwise parsed as register
+// addresses. Nothing is written if a key is neither, or a value does not
+// fit in a byte.
+func (smb *SMBus) WriteBankJSON(data []byte, names map[byte]string) error {
+	var bank map[string]byte
+	if err := json.Unmarshal(data, &bank); err != nil {
+		return err
+	}
+	byName := make(map[string]byte, len(names))
+	for cmd, name := range names {
+		byName[name] = cmd
+	}
+	regs := make([]RegVal, 0, len(bank))
+	for key, val := range bank {
+		cmd, ok := byName[key]
+		if !ok {
+			n, err := strconv.ParseUint(key, 0, 8)
+			if err != nil {
+				return fmt.Errorf("Unknown register %q", key)
+			}
+			cmd = byte(n)
+		}
+		regs = append(regs, RegVal{Cmd: cmd, Value: val})
+	}
+	sort.Slice(regs, func(i, j int) bool { return regs[i].Cmd < regs[j].Cmd })
+	defer smb.lock()()
+	for _, r := range regs {
+		if err := smb.writeByteData(r.Cmd, r.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package smbus
+
+import "testing"
+
+func TestBankJSON(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	copy(d.regs[0x00:], []byte{0x1F, 0x80, 0x05})
+	smb := f.open(t, 0x48)
+	names := map[byte]string{0x00: "config", 0x01: "status"}
+
+	data, err := smb.ReadBankJSON(0x00, 3, names)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"0x02":5,"config":31,"status":128}` {
+		t.Fatalf("got %s", data)
+	}
+	for cmd := byte(0); cmd < 3; cmd++ {
+		f.setReg(0x48, cmd, 0)
+	}
+	if err := smb.WriteBankJSON(data, names); err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []byte{0x1F, 0x80, 0x05} {
+		if got := f.reg(0x48, byte(i)); got != want {
+			t.Fatalf("register 0x%02X holds 0x%02X, want 0x%02X", i, got, want)
+		}
+	}
+
+	writes := f.count("write_byte_data")
+	if err := smb.WriteBankJSON([]byte(`{"config":1,"gain":2}`), names); err == nil {
+		t.Fatal("an unknown register name was accepted")
+	}
+	if f.count("write_byte_data") != writes {
+		t.Fatal("registers were written despite the unknown name")
+	}
+}
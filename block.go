@@ -0,0 +1,160 @@
+package smbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Writes data followed by a trailing checksum byte computed by checksum as
+// one SMBus block write. This is for devices that expect an application
+// level checksum, independent of PEC. data plus the checksum may not exceed
+// the 32 byte block limit.
+func (smb *SMBus) WriteBlockWithChecksum(cmd byte, data []byte, checksum func([]byte) byte) error {
+	if len(data) == 0 {
+		return errors.New("Block data must not be empty")
+	}
+	if len(data)+1 > 32 {
+		return fmt.Errorf("Block data plus checksum is %d bytes, the limit is 32", len(data)+1)
+	}
+	buf := make([]byte, len(data), len(data)+1)
+	copy(buf, data)
+	buf = append(buf, checksum(data))
+	_, err := smb.Write_block_data(cmd, buf)
+	return err
+}
+
+// Reads a block starting at register cmd into out, a pointer to a fixed-size
+// value as accepted by encoding/binary, and also returns the raw bytes that
+// were decoded. Multi-byte fields are decoded little-endian, like SMBus
+// words. The size of out may not exceed the 32 byte block limit.
+func (smb *SMBus) ReadStructRaw(cmd byte, out interface{}) ([]byte, error) {
+	size := binary.Size(out)
+	if size <= 0 || size > 32 {
+		return nil, fmt.Errorf("Struct size must be 1 to 32 bytes, got %d", size)
+	}
+	raw := make([]byte, size)
+	n, err := smb.Read_i2c_block_data(cmd, raw)
+	if err != nil {
+		return nil, err
+	}
+	if n != size {
+		return raw[:n], fmt.Errorf("Short block read: got %d of %d bytes", n, size)
+	}
+	if err := binary.Read(bytes.NewReader(raw), binary.LittleEndian, out); err != nil {
+		return raw, err
+	}
+	return raw, nil
+}
+
+// Attempts made per chunk by UploadFirmware before giving up
+const uploadAttempts = 3
+
+// Returned by UploadFirmware, giving the offset of the chunk that could not
+// be written
+type UploadError struct {
+	Offset int
+	Err    error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("Firmware upload failed at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// Writes data to register cmd in chunks of chunkSize bytes (1 to 32) using
+// i2c block writes. If verify is not nil it is called after each chunk is
+// written, typically to read it back or check a status register, and a
+// chunk that fails to write or verify is retried up to three times in
+// total. On failure an *UploadError holding the chunk offset is returned.
+func (smb *SMBus) UploadFirmware(cmd byte, data []byte, chunkSize int, verify func(chunk []byte) error) error {
+	if chunkSize < 1 || chunkSize > 32 {
+		return errors.New("Chunk size must be between 1 and 32")
+	}
+	for off := 0; off < len(data); off += chunkSize {
+		end := off + chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := data[off:end]
+		var err error
+		for attempt := 0; attempt < uploadAttempts; attempt++ {
+			if _, err = smb.Write_i2c_block_data(cmd, chunk); err != nil {
+				continue
+			}
+			if verify != nil {
+				err = verify(chunk)
+			}
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return &UploadError{Offset: off, Err: err}
+		}
+	}
+	return nil
+}
+
+// Writes buf, which may exceed the 32 byte block limit, to consecutive
+// registers starting at cmd, in i2c block writes of up to 32 bytes. Same as
+// Write_i2c_block_chunked with a chunk size of 32.
+func (smb *SMBus) Write_i2c_block_large(cmd byte, buf []byte) (int, error) {
+	return smb.Write_i2c_block_chunked(cmd, buf, 32)
+}
+
+// Writes buf to consecutive registers starting at cmd in i2c block writes
+// of up to chunkSize bytes (1 to 32), each to the register at its offset
+// from cmd. Use a smaller chunk size for devices with smaller page buffers.
+// The writes run back to back under the bus lock and stop at the first
+// failure; the number of bytes written before it is returned with the
+// error.
+func (smb *SMBus) Write_i2c_block_chunked(cmd byte, buf []byte, chunkSize int) (int, error) {
+	if chunkSize < 1 || chunkSize > 32 {
+		return 0, errors.New("Chunk size must be between 1 and 32")
+	}
+	if err := checkRange(cmd, len(buf)); err != nil {
+		return 0, err
+	}
+	defer smb.lock()()
+	for off := 0; off < len(buf); off += chunkSize {
+		end := off + chunkSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		if _, err := smb.writeI2CBlockData(cmd+byte(off), buf[off:end]); err != nil {
+			return off, fmt.Errorf("Block write failed at offset %d: %w", off, err)
+		}
+	}
+	return len(buf), nil
+}
+
+// Reads length bytes from consecutive registers starting at start, such as
+// the contents of a 24C02 EEPROM, in i2c block reads of up to 32 bytes,
+// and returns them as one slice. The reads run under the bus lock.
+func (smb *SMBus) Read_eeprom(start byte, length int) ([]byte, error) {
+	if err := checkRange(start, length); err != nil {
+		return nil, err
+	}
+	out := make([]byte, length)
+	defer smb.rlock()()
+	for off := 0; off < length; {
+		end := off + 32
+		if end > length {
+			end = length
+		}
+		n, err := smb.readI2CBlockData(start+byte(off), out[off:end])
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("Device returned no data at offset %d", off)
+		}
+		off += n
+	}
+	return out, nil
+}
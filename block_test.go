@@ -0,0 +1,202 @@
+package smbus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func TestWriteBlockWithChecksum(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	sum := func(b []byte) byte {
+		var s byte
+		for _, v := range b {
+			s += v
+		}
+		return s
+	}
+	data := []byte{0x10, 0x20, 0x30}
+	if err := smb.WriteBlockWithChecksum(0x40, data, sum); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	sent := d.blocks[0x40]
+	f.mu.Unlock()
+	if !bytes.Equal(sent, []byte{0x10, 0x20, 0x30, 0x60}) {
+		t.Fatalf("sent % X, want the data followed by its sum", sent)
+	}
+	if !bytes.Equal(data, []byte{0x10, 0x20, 0x30}) {
+		t.Fatal("the caller's data was modified")
+	}
+	if err := smb.WriteBlockWithChecksum(0x40, make([]byte, 32), sum); err == nil {
+		t.Fatal("32 bytes of data plus the checksum were accepted")
+	}
+}
+
+func TestReadStructRaw(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	copy(d.regs[0x20:], []byte{0x34, 0x12, 0x07, 0xFE, 0xFF})
+	smb := f.open(t, 0x48)
+
+	var out struct {
+		Temp   uint16
+		Status uint8
+		Offset int16
+	}
+	raw, err := smb.ReadStructRaw(0x20, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, []byte{0x34, 0x12, 0x07, 0xFE, 0xFF}) {
+		t.Fatalf("raw bytes % X", raw)
+	}
+	if out.Temp != 0x1234 || out.Status != 0x07 || out.Offset != -2 {
+		t.Fatalf("decoded %+v", out)
+	}
+}
+
+func TestUploadFirmware(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	// the first attempt at the second chunk arrives corrupted
+	writes := 0
+	d.onWrite = func(reg, value byte) error {
+		if reg == 0x40 {
+			writes++
+		}
+		if writes == 2 {
+			value ^= 0xFF
+		}
+		d.regs[reg] = value
+		return nil
+	}
+	smb := f.open(t, 0x48)
+
+	verify := func(chunk []byte) error {
+		got := make([]byte, len(chunk))
+		if _, err := smb.Read_i2c_block_data(0x40, got); err != nil {
+			return err
+		}
+		if !bytes.Equal(got, chunk) {
+			return fmt.Errorf("read back % X", got)
+		}
+		return nil
+	}
+	data := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	if err := smb.UploadFirmware(0x40, data, 4, verify); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("write_i2c_block_data"); n != 3 {
+		t.Fatalf("%d block writes, want the corrupted chunk written again", n)
+	}
+
+	errStatus := errors.New("device reports a write error")
+	err := smb.UploadFirmware(0x40, data, 4, func(chunk []byte) error {
+		if chunk[0] == 5 {
+			return errStatus
+		}
+		return nil
+	})
+	var uerr *UploadError
+	if !errors.As(err, &uerr) || uerr.Offset != 4 || !errors.Is(err, errStatus) {
+		t.Fatalf("got %v, want an UploadError at offset 4", err)
+	}
+}
+
+func TestWriteI2CBlockChunked(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x50)
+	smb := f.open(t, 0x50)
+	var cmds []byte
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "write_i2c_block_data" {
+			cmds = append(cmds, cmd)
+		}
+		return nil
+	}
+
+	buf := make([]byte, 70)
+	for i := range buf {
+		buf[i] = byte(i + 1)
+	}
+	if n, err := smb.Write_i2c_block_large(0x10, buf); err != nil || n != 70 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	// 32 + 32 + 6 bytes, each chunk at its offset from the start register
+	if want := []byte{0x10, 0x30, 0x50}; !bytes.Equal(cmds, want) {
+		t.Fatalf("chunks written at % X, want % X", cmds, want)
+	}
+	for i, v := range buf {
+		if got := f.reg(0x50, 0x10+byte(i)); got != v {
+			t.Fatalf("register 0x%02X holds %d, want %d", 0x10+i, got, v)
+		}
+	}
+
+	cmds = nil
+	if n, err := smb.Write_i2c_block_chunked(0x00, buf[:25], 10); err != nil || n != 25 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if want := []byte{0x00, 0x0A, 0x14}; !bytes.Equal(cmds, want) {
+		t.Fatalf("chunks written at % X, want % X", cmds, want)
+	}
+
+	// a failure reports the bytes written before it
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "write_i2c_block_data" && cmd == 0x0A {
+			return syscall.EIO
+		}
+		return nil
+	}
+	if n, err := smb.Write_i2c_block_chunked(0x00, buf[:25], 10); !errors.Is(err, syscall.EIO) || n != 10 {
+		t.Fatalf("got %d, %v, want 10 bytes and EIO", n, err)
+	}
+
+	for _, size := range []int{0, 33} {
+		if _, err := smb.Write_i2c_block_chunked(0x00, buf, size); err == nil {
+			t.Errorf("chunk size %d accepted", size)
+		}
+	}
+	if _, err := smb.Write_i2c_block_large(0xF0, buf); err == nil {
+		t.Error("write past register 0xFF accepted")
+	}
+}
+
+func TestReadEEPROM(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x50)
+	for i := range d.regs {
+		d.regs[i] = byte(i) ^ 0x5A
+	}
+	smb := f.open(t, 0x50)
+	var cmds []byte
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "read_i2c_block_data" {
+			cmds = append(cmds, cmd)
+		}
+		return nil
+	}
+
+	got, err := smb.Read_eeprom(0x08, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, d.regs[0x08:0x58]) {
+		t.Fatalf("got % X, want % X", got, d.regs[0x08:0x58])
+	}
+	if want := []byte{0x08, 0x28, 0x48}; !bytes.Equal(cmds, want) {
+		t.Fatalf("block reads at % X, want % X", cmds, want)
+	}
+
+	if _, err := smb.Read_eeprom(0xF0, 32); err == nil {
+		t.Error("read past register 0xFF accepted")
+	}
+	if _, err := smb.Read_eeprom(0x00, 0); err == nil {
+		t.Error("zero length accepted")
+	}
+}
@@ -0,0 +1,57 @@
+package smbus
+
+import "time"
+
+type rangeKey struct {
+	start byte
+	count int
+}
+
+type rangeEntry struct {
+	values []byte
+	read   time.Time
+}
+
+// Reads count consecutive registers starting at start and returns them keyed
+// by register. The whole range is cached on the handle and served from the
+// cache until ttl has passed since it was read, after which it is read again
+// as a unit. Concurrent callers share a single refresh.
+func (smb *SMBus) ReadRangeCached(start byte, count int, ttl time.Duration) (map[byte]byte, error) {
+	if err := checkRange(start, count); err != nil {
+		return nil, err
+	}
+	key := rangeKey{start, count}
+	smb.cacheMu.Lock()
+	defer smb.cacheMu.Unlock()
+	entry, ok := smb.ranges[key]
+	if !ok || time.Since(entry.read) >= ttl {
+		values, err := smb.readRange(start, count)
+		if err != nil {
+			return nil, err
+		}
+		entry = rangeEntry{values: values, read: time.Now()}
+		if smb.ranges == nil {
+			smb.ranges = make(map[rangeKey]rangeEntry)
+		}
+		smb.ranges[key] = entry
+	}
+	out := make(map[byte]byte, count)
+	for i, val := range entry.values {
+		out[start+byte(i)] = val
+	}
+	return out, nil
+}
+
+// Reads count consecutive byte registers under the bus lock
+func (smb *SMBus) readRange(start byte, count int) ([]byte, error) {
+	defer smb.rlock()()
+	values := make([]byte, count)
+	for i := range values {
+		val, err := smb.readByteData(start + byte(i))
+		if err != nil {
+			return nil, err
+		}
+		values[i] = val
+	}
+	return values, nil
+}
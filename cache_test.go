@@ -0,0 +1,36 @@
+package smbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadRangeCached(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.regs[0x10], d.regs[0x11] = 0x01, 0x02
+	smb := f.open(t, 0x48)
+
+	const ttl = 50 * time.Millisecond
+	for i := 0; i < 3; i++ {
+		got, err := smb.ReadRangeCached(0x10, 2, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[0x10] != 0x01 || got[0x11] != 0x02 {
+			t.Fatalf("got %v", got)
+		}
+	}
+	if n := f.count("read_byte_data"); n != 2 {
+		t.Fatalf("%d register reads within the TTL, want the range read once", n)
+	}
+	f.setReg(0x48, 0x11, 0x22)
+	time.Sleep(ttl)
+	got, err := smb.ReadRangeCached(0x10, 2, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0x11] != 0x22 || f.count("read_byte_data") != 4 {
+		t.Fatalf("got %v after the TTL, want the range read again", got)
+	}
+}
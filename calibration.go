@@ -0,0 +1,92 @@
+package smbus
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Factory calibration coefficients read from a device, together with the
+// device specific compensation formula that turns raw readings into
+// physical values.
+type Calibration struct {
+	// first register the coefficients were read from
+	Start byte
+	// coefficient bytes in register order
+	Coeffs []byte
+	// compensation formula, typically taken from the device datasheet
+	Compensate func(raw int32, cal Calibration) float64
+}
+
+// Reads length calibration bytes starting at startCmd. Set Compensate on
+// the result before calling Convert.
+func (smb *SMBus) ReadCalibration(startCmd byte, length int) (Calibration, error) {
+	if err := checkRange(startCmd, length); err != nil {
+		return Calibration{}, err
+	}
+	coeffs, err := smb.readRange(startCmd, length)
+	if err != nil {
+		return Calibration{}, err
+	}
+	return Calibration{Start: startCmd, Coeffs: coeffs}, nil
+}
+
+// Returns the unsigned 16 bit coefficient stored at byte offset off
+func (cal Calibration) Uint16(off int, order binary.ByteOrder) uint16 {
+	return order.Uint16(cal.Coeffs[off : off+2])
+}
+
+// Returns the signed 16 bit coefficient stored at byte offset off
+func (cal Calibration) Int16(off int, order binary.ByteOrder) int16 {
+	return int16(cal.Uint16(off, order))
+}
+
+// Applies the compensation formula to a raw reading
+func (cal Calibration) Convert(raw int32) (float64, error) {
+	if cal.Compensate == nil {
+		return 0, errors.New("No compensation formula set")
+	}
+	return cal.Compensate(raw, cal), nil
+}
+
+// A point of a calibration curve: the physical value a raw reading stands for
+type CalPoint struct {
+	Raw   byte
+	Value float64
+}
+
+// Reads a byte register and converts it to a physical value by linear
+// interpolation between the two points of the curve that bracket it.
+// points must hold at least two points in strictly ascending Raw order.
+// Readings outside the curve are extrapolated from the nearest two points
+// if extrapolate is set, and clamped to the end values otherwise.
+func (smb *SMBus) ReadCalibrated(cmd byte, points []CalPoint, extrapolate bool) (float64, error) {
+	if len(points) < 2 {
+		return 0, errors.New("Calibration curve needs at least two points")
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i].Raw <= points[i-1].Raw {
+			return 0, errors.New("Calibration points must be in ascending raw order")
+		}
+	}
+	raw, err := smb.Read_byte_data(cmd)
+	if err != nil {
+		return 0, err
+	}
+	last := len(points) - 1
+	if !extrapolate {
+		if raw <= points[0].Raw {
+			return points[0].Value, nil
+		}
+		if raw >= points[last].Raw {
+			return points[last].Value, nil
+		}
+	}
+	// segment containing raw, or the end segment nearest to it
+	i := 1
+	for i < last && raw > points[i].Raw {
+		i++
+	}
+	a, b := points[i-1], points[i]
+	t := (float64(raw) - float64(a.Raw)) / (float64(b.Raw) - float64(a.Raw))
+	return a.Value + t*(b.Value-a.Value), nil
+}
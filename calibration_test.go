@@ -0,0 +1,72 @@
+package smbus
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadCalibration(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x76)
+	// a gain of 3 and an offset of -100, little endian
+	copy(d.regs[0x88:], []byte{0x03, 0x00, 0x9C, 0xFF})
+	smb := f.open(t, 0x76)
+
+	cal, err := smb.ReadCalibration(0x88, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cal.Start != 0x88 || len(cal.Coeffs) != 4 {
+		t.Fatalf("read %d coefficient bytes from 0x%02X", len(cal.Coeffs), cal.Start)
+	}
+	if _, err := cal.Convert(10); err == nil {
+		t.Fatal("Convert worked without a compensation formula")
+	}
+	cal.Compensate = func(raw int32, cal Calibration) float64 {
+		gain := cal.Uint16(0, binary.LittleEndian)
+		offset := cal.Int16(2, binary.LittleEndian)
+		return float64(raw)*float64(gain) + float64(offset)
+	}
+	got, err := cal.Convert(50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 50 {
+		t.Fatalf("converted 50 to %v, want 50*3-100", got)
+	}
+}
+
+func TestReadCalibrated(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	smb := f.open(t, 0x48)
+	curve := []CalPoint{{Raw: 10, Value: 0}, {Raw: 50, Value: 100}, {Raw: 110, Value: 400}}
+
+	for _, tc := range []struct {
+		raw         byte
+		extrapolate bool
+		want        float64
+	}{
+		{50, false, 100},
+		{30, false, 50},
+		{80, false, 250},
+		{0, false, 0},
+		{120, false, 400},
+		{0, true, -25},
+		{120, true, 450},
+	} {
+		f.mu.Lock()
+		d.regs[0x05] = tc.raw
+		f.mu.Unlock()
+		got, err := smb.ReadCalibrated(0x05, curve, tc.extrapolate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("raw %d, extrapolate %v: got %v, want %v", tc.raw, tc.extrapolate, got, tc.want)
+		}
+	}
+	if _, err := smb.ReadCalibrated(0x05, []CalPoint{curve[1], curve[0]}, false); err == nil {
+		t.Fatal("points out of order were accepted")
+	}
+}
@@ -0,0 +1,67 @@
+package smbus
+
+import (
+	"sort"
+	"time"
+)
+
+// A value waiting to be written by WriteByteDataCoalesced
+type coalescedWrite struct {
+	value byte
+	timer *time.Timer
+}
+
+// Writes value to register cmd after window has passed, merging it with
+// other coalesced writes to the same register: if a write to cmd is already
+// pending, only its value is replaced, so a burst of updates results in a
+// single write of the latest value. A value therefore reaches the device up
+// to window after the call. The write happens in the background; its error,
+// if any, is returned by the next FlushCoalesced.
+func (smb *SMBus) WriteByteDataCoalesced(cmd, value byte, window time.Duration) {
+	smb.coalesceMu.Lock()
+	defer smb.coalesceMu.Unlock()
+	if w, ok := smb.coalesced[cmd]; ok {
+		w.value = value
+		return
+	}
+	if smb.coalesced == nil {
+		smb.coalesced = make(map[byte]*coalescedWrite)
+	}
+	w := &coalescedWrite{value: value}
+	w.timer = time.AfterFunc(window, func() {
+		smb.coalesceMu.Lock()
+		defer smb.coalesceMu.Unlock()
+		// FlushCoalesced may have written it already
+		if smb.coalesced[cmd] != w {
+			return
+		}
+		delete(smb.coalesced, cmd)
+		if err := smb.Write_byte_data(cmd, w.value); err != nil && smb.coalesceErr == nil {
+			smb.coalesceErr = err
+		}
+	})
+	smb.coalesced[cmd] = w
+}
+
+// Writes every pending coalesced value now, in register order, and waits
+// for background writes in progress. Returns the first error of those
+// writes or of a background write since the last call. Bus_close calls it.
+func (smb *SMBus) FlushCoalesced() error {
+	smb.coalesceMu.Lock()
+	defer smb.coalesceMu.Unlock()
+	cmds := make([]byte, 0, len(smb.coalesced))
+	for cmd, w := range smb.coalesced {
+		w.timer.Stop()
+		cmds = append(cmds, cmd)
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i] < cmds[j] })
+	err := smb.coalesceErr
+	smb.coalesceErr = nil
+	for _, cmd := range cmds {
+		if werr := smb.Write_byte_data(cmd, smb.coalesced[cmd].value); err == nil {
+			err = werr
+		}
+		delete(smb.coalesced, cmd)
+	}
+	return err
+}
@@ -0,0 +1,59 @@
+package smbus
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWriteByteDataCoalesced(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	for v := byte(1); v <= 20; v++ {
+		smb.WriteByteDataCoalesced(0x10, v, 30*time.Millisecond)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if n := f.count("write_byte_data"); n != 1 {
+		t.Fatalf("%d writes reached the device, want 1", n)
+	}
+	if got := f.reg(0x48, 0x10); got != 20 {
+		t.Fatalf("register holds %d, want the last value 20", got)
+	}
+	if err := smb.FlushCoalesced(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoalescedErrorsAndClose(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	// a failed background write is reported by the next flush
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "write_byte_data" && cmd == 0x11 {
+			return syscall.EIO
+		}
+		return nil
+	}
+	smb.WriteByteDataCoalesced(0x11, 1, time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	if err := smb.FlushCoalesced(); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("flush returned %v, want the EIO of the background write", err)
+	}
+	if err := smb.FlushCoalesced(); err != nil {
+		t.Fatalf("second flush returned %v, want nil", err)
+	}
+
+	// closing writes pending values instead of leaving a timer behind
+	smb.WriteByteDataCoalesced(0x12, 7, time.Hour)
+	if err := smb.Bus_close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.reg(0x48, 0x12); got != 7 {
+		t.Fatalf("register holds %d after close, want 7", got)
+	}
+}
@@ -0,0 +1,26 @@
+package smbus
+
+// The SMBus protocol operations of a device handle. *SMBus implements it;
+// drivers built on this package can accept a Conn instead, so that tests
+// can substitute a fake device.
+type Conn interface {
+	Bus_open(bus uint) error
+	Set_addr(addr byte) error
+	Bus_close() error
+
+	Write_quick(value byte) error
+	Read_byte() (byte, error)
+	Write_byte(value byte) error
+	Read_byte_data(cmd byte) (byte, error)
+	Write_byte_data(cmd, value byte) error
+	Read_word_data(cmd byte) (uint16, error)
+	Write_word_data(cmd byte, value uint16) error
+	Process_call(cmd byte, value uint16) (uint16, error)
+	Read_block_data(cmd byte, buf []byte) (int, error)
+	Write_block_data(cmd byte, buf []byte) (int, error)
+	Read_i2c_block_data(cmd byte, buf []byte) (int, error)
+	Write_i2c_block_data(cmd byte, buf []byte) (int, error)
+	Block_process_call(cmd byte, buf []byte) ([]byte, error)
+}
+
+var _ Conn = (*SMBus)(nil)
@@ -0,0 +1,98 @@
+package smbus
+
+import (
+	"errors"
+	"testing"
+)
+
+// A Conn without a bus behind it: 256 byte registers with word registers
+// stored low byte first
+type memConn struct {
+	open bool
+	addr byte
+	regs [256]byte
+}
+
+var errNoBlock = errors.New("memConn: block transfers are not supported")
+
+func (c *memConn) Bus_open(bus uint) error  { c.open = true; return nil }
+func (c *memConn) Set_addr(addr byte) error { c.addr = addr; return nil }
+func (c *memConn) Bus_close() error         { c.open = false; return nil }
+
+func (c *memConn) Write_quick(value byte) error { return nil }
+func (c *memConn) Read_byte() (byte, error)     { return c.regs[0], nil }
+func (c *memConn) Write_byte(value byte) error  { return nil }
+
+func (c *memConn) Read_byte_data(cmd byte) (byte, error) { return c.regs[cmd], nil }
+
+func (c *memConn) Write_byte_data(cmd, value byte) error {
+	c.regs[cmd] = value
+	return nil
+}
+
+func (c *memConn) Read_word_data(cmd byte) (uint16, error) {
+	return uint16(c.regs[cmd+1])<<8 | uint16(c.regs[cmd]), nil
+}
+
+func (c *memConn) Write_word_data(cmd byte, value uint16) error {
+	c.regs[cmd], c.regs[cmd+1] = byte(value), byte(value>>8)
+	return nil
+}
+
+func (c *memConn) Process_call(cmd byte, value uint16) (uint16, error) {
+	return value, nil
+}
+
+func (c *memConn) Read_block_data(cmd byte, buf []byte) (int, error) {
+	return 0, errNoBlock
+}
+
+func (c *memConn) Write_block_data(cmd byte, buf []byte) (int, error) {
+	return 0, errNoBlock
+}
+
+func (c *memConn) Read_i2c_block_data(cmd byte, buf []byte) (int, error) {
+	return copy(buf, c.regs[cmd:]), nil
+}
+
+func (c *memConn) Write_i2c_block_data(cmd byte, buf []byte) (int, error) {
+	return copy(c.regs[cmd:], buf), nil
+}
+
+func (c *memConn) Block_process_call(cmd byte, buf []byte) ([]byte, error) {
+	return nil, errNoBlock
+}
+
+// A driver written against Conn: reads the 12-bit temperature of an
+// LM75-style sensor in 1/16 degrees
+func readTemp(c Conn) (float64, error) {
+	w, err := c.Read_word_data(0x00)
+	if err != nil {
+		return 0, err
+	}
+	// the sensor sends the high byte first
+	raw := int16(w<<8|w>>8) >> 4
+	return float64(raw) / 16, nil
+}
+
+func TestConnFake(t *testing.T) {
+	c := &memConn{}
+	var conn Conn = c
+	if err := conn.Bus_open(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Set_addr(0x48); err != nil {
+		t.Fatal(err)
+	}
+	c.regs[0x00], c.regs[0x01] = 0x19, 0x80
+	got, err := readTemp(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 25.5 {
+		t.Fatalf("got %v, want 25.5", got)
+	}
+	if err := conn.Bus_close(); err != nil || c.open {
+		t.Fatalf("Bus_close left the fake open: %v", err)
+	}
+}
@@ -0,0 +1,87 @@
+package smbus
+
+import (
+	"context"
+	"fmt"
+)
+
+// Runs fn in its own goroutine and waits for it to finish or for ctx to be
+// done, whichever comes first. In the latter case ctx.Err() is returned and
+// fn keeps running in the background until the transfer completes, so fn
+// must not write to anything the caller still uses.
+func withContext(ctx context.Context, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Same as Read_byte_data, but returns ctx.Err() as soon as ctx is done. The
+// transfer itself cannot be interrupted and completes in the background.
+func (smb *SMBus) ReadByteDataContext(ctx context.Context, cmd byte) (byte, error) {
+	var val byte
+	err := withContext(ctx, func() (err error) {
+		val, err = smb.Read_byte_data(cmd)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return val, nil
+}
+
+// Same as Write_byte_data, but returns ctx.Err() as soon as ctx is done.
+// The transfer itself cannot be interrupted and completes in the
+// background, so the write may still reach the device.
+func (smb *SMBus) WriteByteDataContext(ctx context.Context, cmd, value byte) error {
+	return withContext(ctx, func() error {
+		return smb.Write_byte_data(cmd, value)
+	})
+}
+
+// Same as Read_word_data, but returns ctx.Err() as soon as ctx is done. The
+// transfer itself cannot be interrupted and completes in the background.
+func (smb *SMBus) ReadWordDataContext(ctx context.Context, cmd byte) (uint16, error) {
+	var val uint16
+	err := withContext(ctx, func() (err error) {
+		val, err = smb.Read_word_data(cmd)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return val, nil
+}
+
+// Same as Read_block_data, but returns ctx.Err() as soon as ctx is done.
+// The transfer itself cannot be interrupted and completes in the
+// background; it reads into an internal buffer, so buf is only written if
+// the read finishes before ctx is done. As with Read_block_data, an error
+// is returned if the device sends more bytes than buf holds.
+func (smb *SMBus) ReadBlockDataContext(ctx context.Context, cmd byte, buf []byte) (int, error) {
+	if err := checkBlockLen(buf); err != nil {
+		return 0, err
+	}
+	tmp := make([]byte, 32)
+	var n int
+	err := withContext(ctx, func() (err error) {
+		n, err = smb.Read_block_data(cmd, tmp)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if n > len(buf) {
+		return 0, fmt.Errorf("Device returned %d bytes, buffer holds %d", n, len(buf))
+	}
+	return copy(buf, tmp[:n]), nil
+}
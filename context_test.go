@@ -0,0 +1,54 @@
+package smbus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadByteDataContext(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x10] = 0x5A
+	smb := f.open(t, 0x48)
+
+	v, err := smb.ReadByteDataContext(context.Background(), 0x10)
+	if err != nil || v != 0x5A {
+		t.Fatalf("read 0x%02X, %v", v, err)
+	}
+
+	f.mu.Lock()
+	f.delay = 100 * time.Millisecond
+	f.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := smb.ReadByteDataContext(ctx, 0x10); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want the context error", err)
+	}
+	if d := time.Since(start); d > 80*time.Millisecond {
+		t.Fatalf("returned after %v, not when the context expired", d)
+	}
+	// Let the abandoned transfer finish before the cleanup closes the bus.
+	for f.count("read_byte_data") < 2 {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadBlockDataContext(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).blocks[0x10] = []byte{1, 2, 3, 4}
+	smb := f.open(t, 0x48)
+	ctx := context.Background()
+
+	buf := make([]byte, 4)
+	n, err := smb.ReadBlockDataContext(ctx, 0x10, buf)
+	if err != nil || n != 4 || buf[3] != 4 {
+		t.Fatalf("got %d bytes % X, %v", n, buf[:n], err)
+	}
+	if _, err := smb.ReadBlockDataContext(ctx, 0x10, make([]byte, 3)); err == nil {
+		t.Fatal("a 4 byte reply into a 3 byte buffer was truncated silently")
+	}
+	if _, err := smb.ReadBlockDataContext(ctx, 0x10, nil); err == nil {
+		t.Fatal("an empty buffer was accepted")
+	}
+}
@@ -0,0 +1,220 @@
+package smbus
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// Electrical condition of a bus as inferred by SMBus.BusState
+type BusState int
+
+const (
+	// At least one device answered with real data
+	BusOK BusState = iota
+	// Every probe returned 0xFF, as seen when SDA is stuck high
+	BusFloating
+	// Every probe failed, as seen when SDA is stuck low
+	BusStuck
+)
+
+func (s BusState) String() string {
+	switch s {
+	case BusOK:
+		return "ok"
+	case BusFloating:
+		return "floating"
+	case BusStuck:
+		return "stuck"
+	}
+	return "unknown"
+}
+
+// Probes every regular 7-bit address (0x08 to 0x77) with a Receive Byte and
+// classifies the bus from the results. The bus is floating only if every
+// address answered 0xFF; addresses claimed by a kernel driver count as
+// devices that answered with real data. A bus where nothing answers is
+// reported as BusStuck, so this is only meaningful on a bus known to carry
+// at least one device. The handle's address is restored afterwards.
+func (smb *SMBus) BusState() (BusState, error) {
+	addrs := make([]byte, 0, 0x78-0x08)
+	for addr := byte(0x08); addr <= 0x77; addr++ {
+		addrs = append(addrs, addr)
+	}
+	defer smb.lock()()
+	ff := 0
+	found, err := smb.probeAddrs(addrs, func(addr byte) error {
+		val, err := smb.readByte()
+		if err == nil && val == 0xFF {
+			ff++
+		}
+		return err
+	})
+	if err != nil {
+		return BusOK, err
+	}
+	switch {
+	case len(found) == 0:
+		return BusStuck, nil
+	case ff == len(addrs):
+		return BusFloating, nil
+	}
+	return BusOK, nil
+}
+
+// Times ops i2c block reads of bytesPerOp bytes from register cmd and
+// returns the achieved payload rate in bytes per second. The rate includes
+// protocol and syscall overhead, but comparing it against the raw bit rate
+// still tells a 100kHz bus from a 400kHz one.
+func (smb *SMBus) MeasureThroughput(cmd byte, bytesPerOp, ops int) (bytesPerSec float64, err error) {
+	if bytesPerOp < 1 || bytesPerOp > 32 {
+		return 0, errors.New("Bytes per operation must be between 1 and 32")
+	}
+	if ops < 1 {
+		return 0, errors.New("Operation count must be at least 1")
+	}
+	buf := make([]byte, bytesPerOp)
+	total := 0
+	start := time.Now()
+	for i := 0; i < ops; i++ {
+		n, err := smb.Read_i2c_block_data(cmd, buf)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	elapsed := time.Since(start)
+	if elapsed <= 0 {
+		return 0, errors.New("Transfers completed too quickly to measure")
+	}
+	return float64(total) / elapsed.Seconds(), nil
+}
+
+// Guesses whether the device advances its register pointer during block
+// reads. Two bytes are read with an i2c block read at startCmd and compared
+// with individual reads of startCmd and startCmd+1. This assumes the two
+// registers hold different, stable values; if they are equal, or the block
+// matches neither pattern, an error is returned since nothing can be
+// concluded.
+func (smb *SMBus) DetectAutoIncrement(startCmd byte) (bool, error) {
+	if startCmd == 0xFF {
+		return false, errors.New("Start register must be followed by another register")
+	}
+	defer smb.rlock()()
+	first, err := smb.readByteData(startCmd)
+	if err != nil {
+		return false, err
+	}
+	second, err := smb.readByteData(startCmd + 1)
+	if err != nil {
+		return false, err
+	}
+	if first == second {
+		return false, errors.New("Registers hold equal values, auto-increment is undetectable")
+	}
+	block := make([]byte, 2)
+	n, err := smb.readI2CBlockData(startCmd, block)
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case n == 2 && block[0] == first && block[1] == second:
+		return true, nil
+	case n == 2 && block[0] == first && block[1] == first:
+		return false, nil
+	}
+	return false, errors.New("Block read matches neither access pattern")
+}
+
+// One step of a self-test: Value is written to register Cmd, which is then
+// read back and compared with Expect. Only the bits set in Mask are
+// compared; a zero Mask compares all bits.
+type TestStep struct {
+	Name   string
+	Cmd    byte
+	Value  byte
+	Expect byte
+	Mask   byte
+}
+
+// Outcome of a TestStep. Actual holds the value read back, Err the bus
+// error that prevented the step from completing, if any.
+type TestResult struct {
+	Step   TestStep
+	Pass   bool
+	Actual byte
+	Err    error
+}
+
+// Runs every step in order and returns one result per step. All steps are
+// run even if some fail. The error is non-nil if any step failed.
+func (smb *SMBus) SelfTest(steps []TestStep) ([]TestResult, error) {
+	results := make([]TestResult, len(steps))
+	failed := 0
+	for i, step := range steps {
+		res := TestResult{Step: step}
+		mask := step.Mask
+		if mask == 0 {
+			mask = 0xFF
+		}
+		if res.Err = smb.Write_byte_data(step.Cmd, step.Value); res.Err == nil {
+			res.Actual, res.Err = smb.Read_byte_data(step.Cmd)
+		}
+		res.Pass = res.Err == nil && res.Actual&mask == step.Expect&mask
+		if !res.Pass {
+			failed++
+		}
+		results[i] = res
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d self-test steps failed", failed, len(steps))
+	}
+	return results, nil
+}
+
+// Reads a byte from the device at addr with a Receive Byte and reports
+// whether the adapter tolerated the device stretching the clock: true if
+// the read completed, false if the adapter gave up with ETIMEDOUT. Other
+// failures, such as a NAK, are returned as errors. Run it while the device
+// is busy, for example right after starting a conversion, so that the read
+// is actually stretched. The handle's address is restored afterwards.
+func (smb *SMBus) ProbeClockStretch(addr byte) (bool, error) {
+	defer smb.lock()()
+	err := smb.withAddr(addr, func() error {
+		_, err := smb.readByte()
+		return err
+	})
+	if errors.Is(err, syscall.ETIMEDOUT) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Tests count consecutive registers starting at start as memory: writes
+// pattern(reg) to every register reg, then reads them all back and returns
+// the registers that did not hold their pattern value. Writing everything
+// before reading catches address lines that alias one register onto
+// another. The test runs under the bus lock and overwrites the registers.
+func (smb *SMBus) MemTest(start byte, count int, pattern func(addr int) byte) ([]int, error) {
+	if err := checkRange(start, count); err != nil {
+		return nil, err
+	}
+	defer smb.lock()()
+	for reg := int(start); reg < int(start)+count; reg++ {
+		if err := smb.writeByteData(byte(reg), pattern(reg)); err != nil {
+			return nil, err
+		}
+	}
+	var failed []int
+	for reg := int(start); reg < int(start)+count; reg++ {
+		val, err := smb.readByteData(byte(reg))
+		if err != nil {
+			return nil, err
+		}
+		if val != pattern(reg) {
+			failed = append(failed, reg)
+		}
+	}
+	return failed, nil
+}
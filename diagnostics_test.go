@@ -0,0 +1,205 @@
+package smbus
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBusState(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(f *fakeBus)
+		want  BusState
+	}{
+		{"ok", func(f *fakeBus) {
+			f.add(0x48).regs[0] = 0x12
+		}, BusOK},
+		{"floating", func(f *fakeBus) {
+			for a := uint16(0x08); a <= 0x77; a++ {
+				f.add(a).regs[0] = 0xFF
+			}
+		}, BusFloating},
+		{"mixed", func(f *fakeBus) {
+			// devices reading 0xFF among addresses that NAK
+			f.add(0x10).regs[0] = 0xFF
+			f.add(0x50).regs[0] = 0xFF
+		}, BusOK},
+		{"stuck", func(f *fakeBus) {
+			f.fail = func(op string, addr uint16, cmd byte) error {
+				if op == "read_byte" {
+					return syscall.ETIMEDOUT
+				}
+				return nil
+			}
+		}, BusStuck},
+		{"driver bound", func(f *fakeBus) {
+			f.add(0x20).regs[0] = 0xFF
+			f.busy[0x48] = true
+		}, BusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeBus(t)
+			f.add(0x10)
+			smb := f.open(t, 0x10)
+			tt.setup(f)
+			got, err := smb.BusState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			if smb.addr != 0x10 {
+				t.Fatalf("handle left on 0x%02X", smb.addr)
+			}
+		})
+	}
+}
+
+func TestMeasureThroughput(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.delay = 10 * time.Millisecond
+	smb := f.open(t, 0x48)
+
+	// 5 reads of 16 bytes at 10ms each: at most 1600 bytes per second
+	rate, err := smb.MeasureThroughput(0x00, 16, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate > 1600 || rate < 800 {
+		t.Fatalf("measured %.0f bytes/s, want just under 1600", rate)
+	}
+	if n := f.count("read_i2c_block_data"); n != 5 {
+		t.Fatalf("%d block reads, want 5", n)
+	}
+}
+
+func TestDetectAutoIncrement(t *testing.T) {
+	for _, autoInc := range []bool{true, false} {
+		f := newFakeBus(t)
+		d := f.add(0x48)
+		d.regs[0x10], d.regs[0x11] = 0x12, 0x34
+		d.noAutoInc = !autoInc
+		smb := f.open(t, 0x48)
+
+		got, err := smb.DetectAutoIncrement(0x10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != autoInc {
+			t.Fatalf("detected %v, want %v", got, autoInc)
+		}
+	}
+
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+	if _, err := smb.DetectAutoIncrement(0x10); err == nil {
+		t.Fatal("registers holding equal values gave a result")
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.onWrite = func(reg, value byte) error {
+		switch reg {
+		case 0x02:
+			// bit 7 is a read-only status bit that stays set
+			value |= 0x80
+		case 0x03:
+			return syscall.EIO
+		}
+		d.regs[reg] = value
+		return nil
+	}
+	smb := f.open(t, 0x48)
+
+	steps := []TestStep{
+		{Name: "scratch", Cmd: 0x01, Value: 0xA5, Expect: 0xA5},
+		{Name: "masked", Cmd: 0x02, Value: 0x15, Expect: 0x15, Mask: 0x7F},
+		{Name: "unmasked", Cmd: 0x02, Value: 0x15, Expect: 0x15},
+		{Name: "write fails", Cmd: 0x03, Value: 0x01, Expect: 0x01},
+	}
+	results, err := smb.SelfTest(steps)
+	if err == nil {
+		t.Fatal("failing steps gave no error")
+	}
+	if len(results) != len(steps) {
+		t.Fatalf("%d results for %d steps", len(results), len(steps))
+	}
+	for i, pass := range []bool{true, true, false, false} {
+		if results[i].Pass != pass {
+			t.Fatalf("step %q passed %v, want %v", steps[i].Name, results[i].Pass, pass)
+		}
+	}
+	if results[2].Actual != 0x95 {
+		t.Fatalf("unmasked step read 0x%02X, want 0x95", results[2].Actual)
+	}
+	if !errors.Is(results[3].Err, syscall.EIO) {
+		t.Fatalf("failed write gave %v", results[3].Err)
+	}
+}
+
+func TestProbeClockStretch(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x40)
+	f.add(0x41)
+	f.add(0x48)
+	// the device at 0x40 stretches the clock longer than the adapter allows
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "read_byte" && addr == 0x40 {
+			return syscall.ETIMEDOUT
+		}
+		return nil
+	}
+	smb := f.open(t, 0x48)
+
+	for _, tc := range []struct {
+		addr byte
+		ok   bool
+		fail bool
+	}{
+		{0x41, true, false},
+		{0x40, false, false},
+		{0x42, false, true},
+	} {
+		ok, err := smb.ProbeClockStretch(tc.addr)
+		if ok != tc.ok || (err != nil) != tc.fail {
+			t.Fatalf("0x%02X: got %v, %v", tc.addr, ok, err)
+		}
+	}
+	if smb.addr != 0x48 {
+		t.Fatalf("handle left on 0x%02X", smb.addr)
+	}
+}
+
+func TestMemTest(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x50)
+	// register 0x13 is stuck at zero and writes to 0x17 land in 0x16
+	d.onWrite = func(reg, value byte) error {
+		switch reg {
+		case 0x13:
+			value = 0
+		case 0x17:
+			reg = 0x16
+		}
+		d.regs[reg] = value
+		return nil
+	}
+	smb := f.open(t, 0x50)
+
+	failed, err := smb.MemTest(0x10, 16, func(addr int) byte { return byte(addr) ^ 0xA5 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(failed) != "[19 22 23]" {
+		t.Fatalf("reported %v, want registers 0x13, 0x16 and 0x17", failed)
+	}
+}
@@ -0,0 +1,78 @@
+package smbus
+
+import (
+	"sync"
+	"time"
+)
+
+// Length of the sliding window MaxDutyCycle is measured over
+const dutyWindow = 100 * time.Millisecond
+
+// Tracks the bus time used by recent transactions
+type dutyCycle struct {
+	mu    sync.Mutex
+	spans []dutySpan
+}
+
+type dutySpan struct {
+	start, end time.Time
+}
+
+// Returns the time spent in transactions during the window ending at now,
+// dropping spans that ended before it.
+func (d *dutyCycle) busy(now time.Time) time.Duration {
+	from := now.Add(-dutyWindow)
+	keep := d.spans[:0]
+	var busy time.Duration
+	for _, s := range d.spans {
+		if !s.end.After(from) {
+			continue
+		}
+		keep = append(keep, s)
+		if s.start.Before(from) {
+			busy += s.end.Sub(from)
+		} else {
+			busy += s.end.Sub(s.start)
+		}
+	}
+	d.spans = keep
+	return busy
+}
+
+// Blocks until the bus time used in the current window is below max times
+// the window length. Busy time leaves the window no faster than time
+// passes, so sleeping for the excess is the shortest wait that can help.
+func (d *dutyCycle) wait(max float64) {
+	allowed := time.Duration(max * float64(dutyWindow))
+	for {
+		d.mu.Lock()
+		excess := d.busy(time.Now()) - allowed
+		d.mu.Unlock()
+		if excess < 0 {
+			return
+		}
+		time.Sleep(excess + time.Microsecond)
+	}
+}
+
+// Records a finished transaction
+func (d *dutyCycle) record(start, end time.Time) {
+	d.mu.Lock()
+	d.spans = append(d.spans, dutySpan{start, end})
+	d.mu.Unlock()
+}
+
+// Delays the caller until the handle is within MaxDutyCycle and the pacers
+// added with UsePacer allow it. Called before taking the bus lock: a whole
+// sequence of transfers runs once the budget allows, so a long sequence
+// can overshoot the cap briefly.
+func (smb *SMBus) pace() {
+	if smb.MaxDutyCycle > 0 && smb.MaxDutyCycle < 1 {
+		smb.duty.wait(smb.MaxDutyCycle)
+	}
+	if pacers := smb.pacers.Load(); pacers != nil {
+		for _, p := range *pacers {
+			p.Pace()
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package smbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaxDutyCycle(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.add(0x49)
+	f.delay = 10 * time.Millisecond
+	capped := f.open(t, 0x48)
+	capped.MaxDutyCycle = 0.2
+	other := f.open(t, 0x49)
+
+	// 20ms of bus time per 100ms window: five 10ms reads need at least two
+	// more windows after the first
+	done := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		for i := 0; i < 5; i++ {
+			if _, err := capped.Read_byte_data(0); err != nil {
+				t.Error(err)
+			}
+		}
+		done <- time.Since(start)
+	}()
+
+	// the capped handle waits without the bus lock, so another handle on
+	// the bus gets through quickly
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	if _, err := other.Read_byte_data(0); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 40*time.Millisecond {
+		t.Errorf("uncapped read took %v while the capped handle was waiting", d)
+	}
+
+	if d := <-done; d < 150*time.Millisecond {
+		t.Errorf("five capped reads took %v, want at least 150ms", d)
+	}
+}
@@ -0,0 +1,380 @@
+package smbus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// A simulated adapter and the devices behind it. It implements transport,
+// so handles opened while it is installed talk to it instead of the kernel.
+type fakeBus struct {
+	// bus device file the handles open; the fake ignores its contents
+	path string
+
+	mu sync.Mutex
+	// devices by address; a missing device does not acknowledge (ENXIO)
+	devs map[uint16]*fakeDev
+	// addresses claimed by a kernel driver: I2C_SLAVE fails with EBUSY
+	busy map[uint16]bool
+	// address selected on each file descriptor
+	sel map[uintptr]uint16
+	// functionality mask returned by I2C_FUNCS
+	funcMask uint64
+	// operations in the order they happened, such as "slave 0x48" or
+	// "read_byte_data 0x48 0x10"
+	log []string
+	// time every transaction takes, spent without holding mu
+	delay time.Duration
+	// if set, called before every operation; a non-nil error fails it
+	fail func(op string, addr uint16, cmd byte) error
+}
+
+// A simulated device: 256 byte registers with an auto-incrementing pointer
+type fakeDev struct {
+	regs [256]byte
+	// register pointer, set by writes and used by Receive Byte and raw reads
+	ptr byte
+	// block reads return the same register over and over
+	noAutoInc bool
+	// replies of SMBus block reads, by command
+	blocks map[byte][]byte
+	// if set, replaces register reads and writes
+	onRead  func(reg byte) (byte, error)
+	onWrite func(reg, value byte) error
+	// if set, answers process calls and block process calls
+	call func(cmd byte, in []byte) ([]byte, error)
+}
+
+// Installs a fake bus as the transport of handles opened during the test.
+// It is bus 1: New(1, addr) opens it.
+func newFakeBus(t *testing.T) *fakeBus {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "i2c-1")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeBus{
+		path:     path,
+		devs:     make(map[uint16]*fakeDev),
+		busy:     make(map[uint16]bool),
+		sel:      make(map[uintptr]uint16),
+		funcMask: ^uint64(0),
+	}
+	prev, prevRoot := defaultTransport, devRoot
+	defaultTransport, devRoot = f, filepath.Dir(path)
+	t.Cleanup(func() { defaultTransport, devRoot = prev, prevRoot })
+	return f
+}
+
+// Creates the device file of one more bus, i2c-n, next to bus 1. All buses
+// of the fake share its devices.
+func (f *fakeBus) addBus(t *testing.T, n uint) {
+	t.Helper()
+	path := filepath.Join(filepath.Dir(f.path), fmt.Sprintf("i2c-%d", n))
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Adds a device at addr and returns it
+func (f *fakeBus) add(addr uint16) *fakeDev {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	d := &fakeDev{blocks: make(map[byte][]byte)}
+	f.devs[addr] = d
+	return d
+}
+
+// Opens a handle on the fake bus at addr, closed when the test ends
+func (f *fakeBus) open(t *testing.T, addr byte) *SMBus {
+	t.Helper()
+	smb, err := New(1, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { smb.Bus_close() })
+	return smb
+}
+
+// Returns register reg of the device at addr
+func (f *fakeBus) reg(addr uint16, reg byte) byte {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.devs[addr].regs[reg]
+}
+
+// Sets register reg of the device at addr
+func (f *fakeBus) setReg(addr uint16, reg, value byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.devs[addr].regs[reg] = value
+}
+
+// Returns how many logged operations start with op, such as "slave"
+func (f *fakeBus) count(op string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, e := range f.log {
+		if e == op || len(e) > len(op) && e[:len(op)+1] == op+" " {
+			n++
+		}
+	}
+	return n
+}
+
+// Returns a copy of the operation log
+func (f *fakeBus) ops() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.log...)
+}
+
+// Records op and runs the fail hook; must be called with mu held
+func (f *fakeBus) begin(op string, addr uint16, cmd byte, format string, args ...interface{}) error {
+	f.log = append(f.log, op+fmt.Sprintf(format, args...))
+	if f.fail != nil {
+		return f.fail(op, addr, cmd)
+	}
+	return nil
+}
+
+func (f *fakeBus) ioctl(fd, req, arg uintptr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch req {
+	case i2c_SLAVE, i2c_SLAVE_FORCE:
+		op := "slave"
+		if req == i2c_SLAVE_FORCE {
+			op = "slave_force"
+		}
+		if err := f.begin(op, uint16(arg), 0, " 0x%02X", arg); err != nil {
+			return err
+		}
+		if req == i2c_SLAVE && f.busy[uint16(arg)] {
+			return syscall.EBUSY
+		}
+		f.sel[fd] = uint16(arg)
+		return nil
+	case i2c_TENBIT:
+		return f.begin("tenbit", 0, 0, " %d", arg)
+	case i2c_PEC:
+		return f.begin("pec", 0, 0, " %d", arg)
+	case i2c_TIMEOUT:
+		return f.begin("timeout", 0, 0, " %d", arg)
+	case i2c_RETRIES:
+		return f.begin("retries", 0, 0, " %d", arg)
+	}
+	return syscall.ENOTTY
+}
+
+func (f *fakeBus) funcs(fd uintptr) (uint64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("funcs", 0, 0, ""); err != nil {
+		return 0, err
+	}
+	return f.funcMask, nil
+}
+
+func (f *fakeBus) wait() {
+	f.mu.Lock()
+	d := f.delay
+	f.mu.Unlock()
+	time.Sleep(d)
+}
+
+func (f *fakeBus) smbus(fd uintptr, readWrite, cmd byte, size int, data *smbusData) error {
+	f.wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	read := readWrite == i2c_SMBUS_READ
+	var op string
+	switch size {
+	case i2c_SMBUS_QUICK:
+		op = "quick"
+	case i2c_SMBUS_BYTE:
+		op = map[bool]string{true: "read_byte", false: "write_byte"}[read]
+	case i2c_SMBUS_BYTE_DATA:
+		op = map[bool]string{true: "read_byte_data", false: "write_byte_data"}[read]
+	case i2c_SMBUS_WORD_DATA:
+		op = map[bool]string{true: "read_word_data", false: "write_word_data"}[read]
+	case i2c_SMBUS_PROC_CALL:
+		op = "process_call"
+	case i2c_SMBUS_BLOCK_DATA:
+		op = map[bool]string{true: "read_block_data", false: "write_block_data"}[read]
+	case i2c_SMBUS_I2C_BLOCK_DATA, i2c_SMBUS_I2C_BLOCK_BROKEN:
+		op = map[bool]string{true: "read_i2c_block_data", false: "write_i2c_block_data"}[read]
+	case i2c_SMBUS_BLOCK_PROC_CALL:
+		op = "block_process_call"
+	default:
+		return syscall.EINVAL
+	}
+	addr := f.sel[fd]
+	if err := f.begin(op, addr, cmd, " 0x%02X 0x%02X", addr, cmd); err != nil {
+		return err
+	}
+	d := f.devs[addr]
+	if d == nil {
+		return syscall.ENXIO
+	}
+	switch size {
+	case i2c_SMBUS_QUICK:
+		return nil
+	case i2c_SMBUS_BYTE:
+		if read {
+			v, err := d.read(d.ptr)
+			data[0] = v
+			return err
+		}
+		d.ptr = cmd
+		return nil
+	case i2c_SMBUS_BYTE_DATA:
+		d.ptr = cmd
+		if read {
+			v, err := d.read(cmd)
+			data[0] = v
+			return err
+		}
+		return d.write(cmd, data[0])
+	case i2c_SMBUS_WORD_DATA:
+		if read {
+			lo, err := d.read(cmd)
+			if err != nil {
+				return err
+			}
+			hi, err := d.read(cmd + 1)
+			data.setWord(uint16(hi)<<8 | uint16(lo))
+			return err
+		}
+		w := data.word()
+		if err := d.write(cmd, byte(w)); err != nil {
+			return err
+		}
+		return d.write(cmd+1, byte(w>>8))
+	case i2c_SMBUS_PROC_CALL, i2c_SMBUS_BLOCK_PROC_CALL:
+		if d.call == nil {
+			return syscall.EOPNOTSUPP
+		}
+		in := data.block()
+		if size == i2c_SMBUS_PROC_CALL {
+			w := data.word()
+			in = []byte{byte(w), byte(w >> 8)}
+		}
+		out, err := d.call(cmd, append([]byte(nil), in...))
+		if err != nil {
+			return err
+		}
+		if size == i2c_SMBUS_PROC_CALL {
+			data.setWord(uint16(out[1])<<8 | uint16(out[0]))
+		} else {
+			data.setBlock(out)
+		}
+		return nil
+	case i2c_SMBUS_BLOCK_DATA:
+		if read {
+			b, ok := d.blocks[cmd]
+			if !ok {
+				return syscall.EPROTO
+			}
+			data.setBlock(b)
+			return nil
+		}
+		d.blocks[cmd] = append([]byte(nil), data.block()...)
+		return nil
+	}
+	// i2c block transfers
+	if read {
+		n := int(data[0])
+		for i := 0; i < n; i++ {
+			reg := cmd
+			if !d.noAutoInc {
+				reg += byte(i)
+			}
+			v, err := d.read(reg)
+			if err != nil {
+				return err
+			}
+			data[1+i] = v
+		}
+		return nil
+	}
+	for i, v := range data.block() {
+		if err := d.write(cmd+byte(i), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeBus) rdwr(fd uintptr, msgs []i2cMsg) (int, error) {
+	f.wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.begin("rdwr", 0, 0, " %d", len(msgs)); err != nil {
+		return 0, err
+	}
+	for _, m := range msgs {
+		d := f.devs[m.addr]
+		if d == nil {
+			return 0, syscall.ENXIO
+		}
+		if m.flags&i2c_M_RD != 0 {
+			for i := range m.buf {
+				v, err := d.read(d.ptr)
+				if err != nil {
+					return 0, err
+				}
+				m.buf[i] = v
+				if !d.noAutoInc {
+					d.ptr++
+				}
+			}
+			continue
+		}
+		d.ptr = m.buf[0]
+		for _, v := range m.buf[1:] {
+			if err := d.write(d.ptr, v); err != nil {
+				return 0, err
+			}
+			d.ptr++
+		}
+	}
+	return len(msgs), nil
+}
+
+func (d *fakeDev) read(reg byte) (byte, error) {
+	if d.onRead != nil {
+		return d.onRead(reg)
+	}
+	return d.regs[reg], nil
+}
+
+func (d *fakeDev) write(reg, value byte) error {
+	if d.onWrite != nil {
+		return d.onWrite(reg, value)
+	}
+	d.regs[reg] = value
+	return nil
+}
+
+// Returns how many file descriptors of the process are open on path
+func openFiles(t *testing.T, path string) int {
+	t.Helper()
+	ents, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot list open files:", err)
+	}
+	n := 0
+	for _, e := range ents {
+		if dst, err := os.Readlink(filepath.Join("/proc/self/fd", e.Name())); err == nil && dst == path {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,85 @@
+package smbus
+
+import "time"
+
+// A simple moving average over the most recent values pushed into it. The
+// zero value is not usable; create one with NewMovingAverage. A
+// MovingAverage is not safe for concurrent use.
+type MovingAverage struct {
+	values []float64
+	next   int
+	full   bool
+	sum    float64
+}
+
+// Creates a moving average over window values. A window below 1 is treated
+// as 1.
+func NewMovingAverage(window int) *MovingAverage {
+	if window < 1 {
+		window = 1
+	}
+	return &MovingAverage{values: make([]float64, window)}
+}
+
+// Adds a value, evicting the oldest one once the window is full, and
+// returns the new average.
+func (ma *MovingAverage) Push(v float64) float64 {
+	ma.sum += v - ma.values[ma.next]
+	ma.values[ma.next] = v
+	ma.next++
+	if ma.next == len(ma.values) {
+		ma.next = 0
+		ma.full = true
+	}
+	return ma.Average()
+}
+
+// Returns the average of the values currently in the window, or 0 if none
+// were pushed yet.
+func (ma *MovingAverage) Average() float64 {
+	n := ma.next
+	if ma.full {
+		n = len(ma.values)
+	}
+	if n == 0 {
+		return 0
+	}
+	return ma.sum / float64(n)
+}
+
+// Reads a byte register, pushes the value into ma and returns the updated
+// average.
+func (smb *SMBus) ReadInto(cmd byte, ma *MovingAverage) (float64, error) {
+	val, err := smb.Read_byte_data(cmd)
+	if err != nil {
+		return ma.Average(), err
+	}
+	return ma.Push(float64(val)), nil
+}
+
+// Tracks the last value and time of a register for ReadRate. The zero value
+// is ready to use. A RateTracker is not safe for concurrent use.
+type RateTracker struct {
+	last  float64
+	at    time.Time
+	valid bool
+}
+
+// Reads a byte register and returns its change per second since the
+// previous call with rt. The first call only records the value and returns
+// 0. A failed read leaves rt unchanged.
+func (smb *SMBus) ReadRate(cmd byte, rt *RateTracker) (valuePerSecond float64, err error) {
+	val, err := smb.Read_byte_data(cmd)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	v := float64(val)
+	if rt.valid {
+		if dt := now.Sub(rt.at).Seconds(); dt > 0 {
+			valuePerSecond = (v - rt.last) / dt
+		}
+	}
+	rt.last, rt.at, rt.valid = v, now, true
+	return valuePerSecond, nil
+}
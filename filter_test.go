@@ -0,0 +1,60 @@
+package smbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadIntoMovingAverage(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	seq := []byte{10, 20, 30, 40, 40, 40}
+	d.onRead = func(reg byte) (byte, error) {
+		v := seq[0]
+		seq = seq[1:]
+		return v, nil
+	}
+	smb := f.open(t, 0x48)
+
+	ma := NewMovingAverage(3)
+	want := []float64{10, 15, 20, 30, 110.0 / 3, 40}
+	for i, w := range want {
+		got, err := smb.ReadInto(0x10, ma)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != w {
+			t.Fatalf("average after %d reads is %v, want %v", i+1, got, w)
+		}
+	}
+}
+
+func TestReadRate(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	smb := f.open(t, 0x48)
+	var rt RateTracker
+
+	d.regs[0x20] = 10
+	if r, err := smb.ReadRate(0x20, &rt); err != nil || r != 0 {
+		t.Fatalf("first call gave %v, %v, want 0", r, err)
+	}
+	start := time.Now()
+	time.Sleep(50 * time.Millisecond)
+	f.setReg(0x48, 0x20, 20)
+	r, err := smb.ReadRate(0x20, &rt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 10 counts over at least 50ms, and at most the time since start
+	lowest := 10 / time.Since(start).Seconds()
+	if r > 200 || r < lowest {
+		t.Fatalf("rate %.1f/s, want between %.1f and 200", r, lowest)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	f.setReg(0x48, 0x20, 15)
+	if r, err := smb.ReadRate(0x20, &rt); err != nil || r >= 0 {
+		t.Fatalf("falling value gave %v, %v, want a negative rate", r, err)
+	}
+}
@@ -0,0 +1,41 @@
+package smbus
+
+// Adapter functionality flags reported by Funcs, as defined in linux/i2c.h
+const (
+	FUNC_I2C                    = 0x00000001
+	FUNC_10BIT_ADDR             = 0x00000002
+	FUNC_PROTOCOL_MANGLING      = 0x00000004
+	FUNC_SMBUS_PEC              = 0x00000008
+	FUNC_NOSTART                = 0x00000010
+	FUNC_SLAVE                  = 0x00000020
+	FUNC_SMBUS_BLOCK_PROC_CALL  = 0x00008000
+	FUNC_SMBUS_QUICK            = 0x00010000
+	FUNC_SMBUS_READ_BYTE        = 0x00020000
+	FUNC_SMBUS_WRITE_BYTE       = 0x00040000
+	FUNC_SMBUS_READ_BYTE_DATA   = 0x00080000
+	FUNC_SMBUS_WRITE_BYTE_DATA  = 0x00100000
+	FUNC_SMBUS_READ_WORD_DATA   = 0x00200000
+	FUNC_SMBUS_WRITE_WORD_DATA  = 0x00400000
+	FUNC_SMBUS_PROC_CALL        = 0x00800000
+	FUNC_SMBUS_READ_BLOCK_DATA  = 0x01000000
+	FUNC_SMBUS_WRITE_BLOCK_DATA = 0x02000000
+	FUNC_SMBUS_READ_I2C_BLOCK   = 0x04000000
+	FUNC_SMBUS_WRITE_I2C_BLOCK  = 0x08000000
+	FUNC_SMBUS_HOST_NOTIFY      = 0x10000000
+)
+
+// Returns the adapter functionality mask, a combination of the FUNC_*
+// flags, as reported by the I2C_FUNCS ioctl. The mask is cached per bus;
+// see RefreshFuncs.
+func (smb *SMBus) Funcs() (uint64, error) {
+	return smb.funcs()
+}
+
+// Reports whether the adapter supports every FUNC_* flag set in flag
+func (smb *SMBus) Supports(flag uint64) (bool, error) {
+	f, err := smb.funcs()
+	if err != nil {
+		return false, err
+	}
+	return f&flag == flag, nil
+}
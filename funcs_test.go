@@ -0,0 +1,33 @@
+package smbus
+
+import "testing"
+
+func TestSupports(t *testing.T) {
+	f := newFakeBus(t)
+	f.funcMask = FUNC_SMBUS_QUICK | FUNC_SMBUS_READ_BYTE_DATA | FUNC_SMBUS_WRITE_BYTE_DATA
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	if got, err := smb.Funcs(); err != nil || got != f.funcMask {
+		t.Fatalf("Funcs() = 0x%08X, %v, want 0x%08X", got, err, f.funcMask)
+	}
+	for _, tc := range []struct {
+		flag uint64
+		want bool
+	}{
+		{FUNC_SMBUS_QUICK, true},
+		{FUNC_SMBUS_READ_BYTE_DATA | FUNC_SMBUS_WRITE_BYTE_DATA, true},
+		{FUNC_I2C, false},
+		// every flag must be supported, not just one of them
+		{FUNC_SMBUS_READ_BYTE_DATA | FUNC_SMBUS_READ_WORD_DATA, false},
+		{FUNC_SMBUS_PEC, false},
+	} {
+		got, err := smb.Supports(tc.flag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Supports(0x%08X) = %v, want %v", tc.flag, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package smbus
+
+import (
+	"sync"
+	"time"
+)
+
+// A transaction kept in a handle's history
+type TransactionRecord struct {
+	Time time.Time
+	// operation name, such as "read_byte_data"
+	Op   string
+	Addr uint16
+	Cmd  byte
+	// bytes transferred, nil for failed transactions
+	Data []byte
+	Err  error
+}
+
+// Ring buffer of the most recent transactions
+type history struct {
+	mu      sync.Mutex
+	records []TransactionRecord
+	next    int
+	full    bool
+}
+
+// Adds rec, keeping at most size records
+func (h *history) add(rec TransactionRecord, size int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.records) != size {
+		h.records = make([]TransactionRecord, size)
+		h.next, h.full = 0, false
+	}
+	h.records[h.next] = rec
+	h.next++
+	if h.next == size {
+		h.next = 0
+		h.full = true
+	}
+}
+
+// Returns the records, oldest first
+func (h *history) list() []TransactionRecord {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !h.full {
+		return append([]TransactionRecord(nil), h.records[:h.next]...)
+	}
+	out := make([]TransactionRecord, 0, len(h.records))
+	out = append(out, h.records[h.next:]...)
+	return append(out, h.records[:h.next]...)
+}
+
+// Returns the most recent transactions of this handle, oldest first, up to
+// HistorySize of them. Empty if HistorySize is 0.
+func (smb *SMBus) History() []TransactionRecord {
+	return smb.history.list()
+}
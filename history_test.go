@@ -0,0 +1,43 @@
+package smbus
+
+import "testing"
+
+func TestHistory(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+	if len(smb.History()) != 0 {
+		t.Fatal("history kept without HistorySize")
+	}
+	smb.HistorySize = 3
+
+	for cmd := byte(0); cmd < 5; cmd++ {
+		if err := smb.Write_byte_data(cmd, cmd+0x10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := smb.History()
+	if len(h) != 3 {
+		t.Fatalf("history holds %d records, want HistorySize", len(h))
+	}
+	for i, rec := range h {
+		cmd := byte(i + 2)
+		if rec.Op != "write_byte_data" || rec.Addr != 0x48 || rec.Cmd != cmd || len(rec.Data) != 1 || rec.Data[0] != cmd+0x10 {
+			t.Fatalf("record %d is %+v, want the write to 0x%02X", i, rec, cmd)
+		}
+		if i > 0 && rec.Time.Before(h[i-1].Time) {
+			t.Fatalf("record %d is older than the one before", i)
+		}
+	}
+
+	if err := smb.Set_addr(0x49); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := smb.Read_byte_data(0x00); err == nil {
+		t.Fatal("read from a missing device succeeded")
+	}
+	last := smb.History()[2]
+	if last.Op != "read_byte_data" || last.Addr != 0x49 || last.Err == nil || last.Data != nil {
+		t.Fatalf("failed read recorded as %+v", last)
+	}
+}
@@ -0,0 +1,119 @@
+package smbus
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Returned, wrapped with the actual and expected values, by Expect
+var ErrUnexpectedValue = errors.New("Unexpected register value")
+
+// Expected register values identifying a device, keyed by register. Several
+// registers can be combined to tell apart chips that share an ID register.
+type Fingerprint map[byte]byte
+
+// Reads every register in the fingerprint, in ascending register order, and
+// reports whether all of them hold the expected value. Reading stops at the
+// first mismatch.
+func (smb *SMBus) Matches(fp Fingerprint) (bool, error) {
+	defer smb.rlock()()
+	return smb.matches(fp)
+}
+
+func (smb *SMBus) matches(fp Fingerprint) (bool, error) {
+	cmds := make([]int, 0, len(fp))
+	for cmd := range fp {
+		cmds = append(cmds, int(cmd))
+	}
+	sort.Ints(cmds)
+	for _, cmd := range cmds {
+		val, err := smb.readByteData(byte(cmd))
+		if err != nil {
+			return false, err
+		}
+		if val != fp[byte(cmd)] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Reads the revision register revCmd and runs fn only if match accepts the
+// revision. Useful for gating silicon-revision specific workarounds during
+// driver initialisation. Returns the error from fn, or nil if fn was not run.
+func (smb *SMBus) IfRevision(revCmd byte, match func(rev byte) bool, fn func() error) error {
+	rev, err := smb.Read_byte_data(revCmd)
+	if err != nil {
+		return err
+	}
+	if !match(rev) {
+		return nil
+	}
+	return fn()
+}
+
+// Tries each candidate address in turn and binds the handle to the first
+// one whose device matches verify, returning that address. Candidates that
+// do not answer are skipped. If none matches, the handle keeps its previous
+// address and an error is returned.
+func (smb *SMBus) AutoSelectAddr(candidates []byte, verify Fingerprint) (byte, error) {
+	defer smb.lock()()
+	restore := smb.saveAddr()
+	for _, addr := range candidates {
+		if err := smb.setAddr(addr); err != nil {
+			continue
+		}
+		if ok, err := smb.matches(verify); err == nil && ok {
+			return addr, nil
+		}
+	}
+	if err := restore(); err != nil {
+		return 0, err
+	}
+	return 0, errors.New("No candidate address matches the fingerprint")
+}
+
+// Reads a register and returns an error wrapping ErrUnexpectedValue, which
+// names the actual and expected value, unless it holds expected.
+func (smb *SMBus) Expect(cmd, expected byte) error {
+	val, err := smb.Read_byte_data(cmd)
+	if err != nil {
+		return err
+	}
+	if val != expected {
+		return fmt.Errorf("%w: register 0x%02X is 0x%02X, expected 0x%02X", ErrUnexpectedValue, cmd, val, expected)
+	}
+	return nil
+}
+
+// SMBus Device Default Address, used for Address Resolution Protocol
+const arpAddr = 0x61
+
+// Reports the SMBus version of the device at addr as "2.0" or "3.0", read
+// from the UDID version field it returns for a directed ARP Get UDID.
+// Devices that do not support ARP, or report an unknown UDID version, give
+// "unknown" without an error. An error is returned if nothing answers at
+// addr. The handle's address is restored afterwards.
+func (smb *SMBus) DetectSMBusVersion(addr byte) (string, error) {
+	if !smb.probe(addr) {
+		return "", fmt.Errorf("No device answers at 0x%02X", addr)
+	}
+	defer smb.lock()()
+	udid := make([]byte, 32)
+	var n int
+	err := smb.withAddr(arpAddr, func() (err error) {
+		n, err = smb.readBlockData(addr<<1|1, udid)
+		return err
+	})
+	if err != nil || n < 2 {
+		return "unknown", nil
+	}
+	switch (udid[1] >> 3) & 0x07 {
+	case 1:
+		return "2.0", nil
+	case 2:
+		return "3.0", nil
+	}
+	return "unknown", nil
+}
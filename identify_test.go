@@ -0,0 +1,138 @@
+package smbus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMatches(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.regs[0x0F], d.regs[0xFE] = 0x33, 0x01
+	smb := f.open(t, 0x48)
+
+	ok, err := smb.Matches(Fingerprint{0x0F: 0x33, 0xFE: 0x01})
+	if err != nil || !ok {
+		t.Fatalf("got %v, %v for a matching fingerprint", ok, err)
+	}
+	ok, err = smb.Matches(Fingerprint{0x0F: 0x33, 0xFE: 0x02})
+	if err != nil || ok {
+		t.Fatalf("got %v, %v for a fingerprint with a different revision", ok, err)
+	}
+}
+
+func TestIfRevision(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0xFF] = 0x02
+	smb := f.open(t, 0x48)
+
+	for _, tc := range []struct {
+		rev  byte
+		runs bool
+	}{{0x02, true}, {0x03, false}} {
+		ran := false
+		err := smb.IfRevision(0xFF, func(rev byte) bool { return rev == tc.rev }, func() error {
+			ran = true
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ran != tc.runs {
+			t.Fatalf("revision 0x%02X: fn ran %v, want %v", tc.rev, ran, tc.runs)
+		}
+	}
+}
+
+func TestAutoSelectAddr(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x0F] = 0x11
+	f.add(0x4A).regs[0x0F] = 0x33
+	f.add(0x20)
+	smb := f.open(t, 0x20)
+	fp := Fingerprint{0x0F: 0x33}
+
+	// 0x48 answers with the wrong ID
+	addr, err := smb.AutoSelectAddr([]byte{0x48, 0x4A, 0x49}, fp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr != 0x4A || smb.addr != 0x4A {
+		t.Fatalf("selected 0x%02X, handle on 0x%02X, want 0x4A", addr, smb.addr)
+	}
+	if v, err := smb.Read_byte_data(0x0F); err != nil || v != 0x33 {
+		t.Fatalf("read 0x%02X, %v after selecting", v, err)
+	}
+
+	if err := smb.Set_addr(0x20); err != nil {
+		t.Fatal(err)
+	}
+	// 0x49 does not answer at all
+	if _, err := smb.AutoSelectAddr([]byte{0x48, 0x49}, fp); err == nil {
+		t.Fatal("no candidate matches, but AutoSelectAddr succeeded")
+	}
+	if smb.addr != 0x20 {
+		t.Fatalf("handle moved to 0x%02X, want it left on 0x20", smb.addr)
+	}
+}
+
+func TestExpect(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0xFE] = 0x54
+	smb := f.open(t, 0x48)
+
+	if err := smb.Expect(0xFE, 0x54); err != nil {
+		t.Fatal(err)
+	}
+	err := smb.Expect(0xFE, 0x55)
+	if !errors.Is(err, ErrUnexpectedValue) {
+		t.Fatalf("got %v, want ErrUnexpectedValue", err)
+	}
+	for _, s := range []string{"0xFE", "0x54", "0x55"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("message %q does not mention %s", err, s)
+		}
+	}
+}
+
+func TestDetectSMBusVersion(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// second UDID byte, holding the version in bits 5:3; 0 for a bus
+		// without an ARP responder
+		udid1 byte
+		want  string
+	}{
+		{"UDID version 1", 0x08, "2.0"},
+		{"UDID version 2", 0x10, "3.0"},
+		{"no ARP", 0, "unknown"},
+	} {
+		f := newFakeBus(t)
+		f.add(0x48)
+		if tc.udid1 != 0 {
+			udid := make([]byte, 17)
+			udid[1] = tc.udid1
+			f.add(arpAddr).blocks[0x48<<1|1] = udid
+		}
+		smb := f.open(t, 0x48)
+
+		got, err := smb.DetectSMBusVersion(0x48)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if smb.addr != 0x48 {
+			t.Fatalf("%s: handle left on 0x%02X", tc.name, smb.addr)
+		}
+	}
+
+	f := newFakeBus(t)
+	f.add(0x20)
+	smb := f.open(t, 0x20)
+	if _, err := smb.DetectSMBusVersion(0x48); err == nil {
+		t.Fatal("an address nothing answers at gave a version")
+	}
+}
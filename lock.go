@@ -0,0 +1,120 @@
+package smbus
+
+import (
+	"sync"
+)
+
+// A reader/writer lock shared by all handles on one bus. Waiters are served
+// strictly in the order they arrived, so a handle polling in a tight loop
+// cannot starve another handle on the same bus. Consecutive readers hold
+// the lock together; a writer waits for the readers ahead of it to finish
+// and readers behind a waiting writer wait for it.
+type busLock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	next    uint64 // ticket handed to the next caller of Lock or RLock
+	serving uint64 // ticket allowed to take the lock
+	readers int    // readers holding the lock
+}
+
+func newBusLock() *busLock {
+	l := &busLock{}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func (l *busLock) Lock() {
+	l.mu.Lock()
+	ticket := l.next
+	l.next++
+	for ticket != l.serving || l.readers > 0 {
+		l.cond.Wait()
+	}
+	l.mu.Unlock()
+}
+
+func (l *busLock) Unlock() {
+	l.mu.Lock()
+	l.serving++
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+func (l *busLock) RLock() {
+	l.mu.Lock()
+	ticket := l.next
+	l.next++
+	for ticket != l.serving {
+		l.cond.Wait()
+	}
+	// let the next waiter in line check whether it can share
+	l.readers++
+	l.serving++
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+func (l *busLock) RUnlock() {
+	l.mu.Lock()
+	l.readers--
+	last := l.readers == 0
+	l.mu.Unlock()
+	if last {
+		l.cond.Broadcast()
+	}
+}
+
+// Takes the bus lock exclusively for a transfer or a sequence of transfers
+// and returns the function releasing it. Waits for MaxDutyCycle and the
+// pacers first, so the wait does not hold up other handles on the bus.
+func (smb *SMBus) lock() func() {
+	smb.pace()
+	smb.mu.Lock()
+	smb.fresh = true
+	return smb.unlock
+}
+
+// Releases the exclusive lock taken by lock or rlock
+func (smb *SMBus) unlock() {
+	smb.fresh = false
+	smb.mu.Unlock()
+}
+
+// Takes the bus lock for a sequence that only reads from the device and
+// returns the function releasing it. The lock is shared with other readers
+// if RWMode is set, exclusive otherwise. Waits like lock.
+func (smb *SMBus) rlock() func() {
+	smb.pace()
+	if smb.RWMode {
+		smb.mu.RLock()
+		// selecting the address changes the handle, which readers sharing
+		// the lock must not do, so take the lock exclusively while a
+		// selection is pending
+		if !smb.reselect {
+			return smb.mu.RUnlock
+		}
+		smb.mu.RUnlock()
+	}
+	smb.mu.Lock()
+	smb.fresh = true
+	return smb.unlock
+}
+
+// Bus locks keyed by bus device path. Entries are kept for the life of the
+// process, there is at most one per bus.
+var busLocks = struct {
+	sync.Mutex
+	locks map[string]*busLock
+}{locks: make(map[string]*busLock)}
+
+// Returns the lock shared by all handles on the bus at path
+func sharedBusLock(path string) *busLock {
+	busLocks.Lock()
+	defer busLocks.Unlock()
+	l, ok := busLocks.locks[path]
+	if !ok {
+		l = newBusLock()
+		busLocks.locks[path] = l
+	}
+	return l
+}
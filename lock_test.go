@@ -0,0 +1,116 @@
+package smbus
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Two goroutines taking the lock in a tight loop must take turns. A plain
+// sync.Mutex lets the goroutine that just unlocked win it back until the
+// other one has waited for a millisecond, so the lock is held briefly.
+func TestBusLockTakesTurns(t *testing.T) {
+	l := newBusLock()
+	var order []int
+	var wg sync.WaitGroup
+	// hold the lock until both goroutines wait for it
+	l.Lock()
+	for g := 0; g < 2; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				l.Lock()
+				order = append(order, g)
+				for held := time.Now(); time.Since(held) < 50*time.Microsecond; {
+				}
+				l.Unlock()
+			}
+		}(g)
+	}
+	for {
+		l.mu.Lock()
+		waiting := l.next - l.serving - 1
+		l.mu.Unlock()
+		if waiting == 2 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	l.Unlock()
+	wg.Wait()
+
+	for i := 2; i < len(order); i++ {
+		if order[i] == order[i-1] && order[i] == order[i-2] {
+			t.Fatalf("goroutine %d held the lock 3 times in a row: %v", order[i], order)
+		}
+	}
+}
+
+func TestRWModeSharesReads(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+	smb.RWMode = true
+	f.delay = 25 * time.Millisecond
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := smb.ReadPair(0x00, 0x02, binary.LittleEndian); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if d := time.Since(start); d > 120*time.Millisecond {
+		t.Errorf("three 50ms read sequences took %v, want them to overlap", d)
+	}
+
+	// an exclusive holder waits for the read ahead of it
+	readDone := make(chan time.Time, 1)
+	go func() {
+		smb.ReadPair(0x00, 0x02, binary.LittleEndian)
+		readDone <- time.Now()
+	}()
+	time.Sleep(10 * time.Millisecond)
+	var locked time.Time
+	smb.DoIoctl(func(fd uintptr) error {
+		locked = time.Now()
+		return nil
+	})
+	if read := <-readDone; locked.Before(read) {
+		t.Errorf("exclusive lock taken %v before the read sequence finished", read.Sub(locked))
+	}
+}
+
+// A pending address selection is made under the exclusive lock, once,
+// even when readers share the lock
+func TestRWModeReselectsOnce(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+	smb.RWMode = true
+	f.delay = 10 * time.Millisecond
+
+	smb.ForceReselectNext()
+	slaves := f.count("slave")
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := smb.Read_byte_data(0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := f.count("slave") - slaves; n != 1 {
+		t.Errorf("address selected %d times, want once", n)
+	}
+}
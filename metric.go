@@ -0,0 +1,33 @@
+package smbus
+
+import (
+	"time"
+)
+
+// A single sample of a register, shaped after a Prometheus sample so it can
+// be handed to a metrics system without this package depending on one.
+type Metric struct {
+	Name      string
+	Labels    map[string]string
+	Value     float64
+	Timestamp time.Time
+}
+
+// Reads a byte register and returns it as a Metric with the given name and
+// labels, stamped with the time of the read. The labels map is copied.
+func (smb *SMBus) ReadMetric(cmd byte, name string, labels map[string]string) (Metric, error) {
+	val, err := smb.Read_byte_data(cmd)
+	if err != nil {
+		return Metric{}, err
+	}
+	m := Metric{
+		Name:      name,
+		Labels:    make(map[string]string, len(labels)),
+		Value:     float64(val),
+		Timestamp: time.Now(),
+	}
+	for k, v := range labels {
+		m.Labels[k] = v
+	}
+	return m, nil
+}
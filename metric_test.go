@@ -0,0 +1,29 @@
+package smbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadMetric(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x05] = 42
+	smb := f.open(t, 0x48)
+
+	labels := map[string]string{"bus": "1"}
+	before := time.Now()
+	m, err := smb.ReadMetric(0x05, "fan_speed", labels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "fan_speed" || m.Value != 42 || m.Labels["bus"] != "1" {
+		t.Fatalf("got %+v", m)
+	}
+	if m.Timestamp.Before(before) || m.Timestamp.After(time.Now()) {
+		t.Fatalf("timestamp %v is not the time of the read", m.Timestamp)
+	}
+	labels["bus"] = "2"
+	if m.Labels["bus"] != "1" {
+		t.Fatal("the metric shares the caller's label map")
+	}
+}
@@ -0,0 +1,129 @@
+package smbus
+
+import (
+	"sync"
+	"time"
+)
+
+// Describes a bus transaction passing through the middleware chain
+type Op struct {
+	// operation name, such as "read_byte_data"
+	Name string
+	// command byte, 0 for operations without one
+	Cmd byte
+	// slave address the transaction goes to
+	Addr uint16
+	// performs the transaction, at the end of the chain
+	run func() error
+	// implements Wait, nil in an Op the handle did not build
+	wait func(d time.Duration) bool
+}
+
+// Performs the transaction described by op
+type OpFunc func(op Op) error
+
+// Wraps the rest of the chain, next, with additional behavior
+type Middleware func(next OpFunc) OpFunc
+
+// Adds middlewares to the chain every transaction of this handle passes
+// through. Middlewares run in the order they were added, the first one
+// outermost, and run under the bus lock, so they must not call methods of
+// the handle. The chain is built here, once per call of Use, without
+// holding the bus lock.
+func (smb *SMBus) Use(mw ...Middleware) {
+	smb.useMu.Lock()
+	defer smb.useMu.Unlock()
+	smb.middleware = append(smb.middleware, mw...)
+	chain := OpFunc(func(op Op) error { return op.run() })
+	for i := len(smb.middleware) - 1; i >= 0; i-- {
+		chain = smb.middleware[i](chain)
+	}
+	smb.mu.Lock()
+	smb.chain = chain
+	smb.mu.Unlock()
+}
+
+// Delays a caller before it takes the bus lock, so the wait does not hold
+// up other handles on the bus
+type Pacer interface {
+	// blocks until the caller may go ahead
+	Pace()
+}
+
+// Adds pacers every call of this handle waits for before it takes the bus
+// lock, after MaxDutyCycle. A call that runs a sequence of transfers waits
+// once for the whole sequence.
+func (smb *SMBus) UsePacer(p ...Pacer) {
+	smb.useMu.Lock()
+	defer smb.useMu.Unlock()
+	var pacers []Pacer
+	if cur := smb.pacers.Load(); cur != nil {
+		pacers = append(pacers, *cur...)
+	}
+	pacers = append(pacers, p...)
+	smb.pacers.Store(&pacers)
+}
+
+// Waits d before the transaction is retried and reports whether it can be.
+// If the transaction is the first of its call, the bus lock is released for
+// the wait and the handle's address selected again afterwards; the
+// transaction cannot be retried if the handle was closed or moved to
+// another address meanwhile. Later transactions of a call are retried at
+// once, as the transfers before them must stay under the same lock.
+func (op Op) Wait(d time.Duration) bool {
+	if op.wait == nil {
+		return true
+	}
+	return op.wait(d)
+}
+
+// Returns a middleware that retries failed transactions, waiting delay
+// before each retry with Op.Wait, until one succeeds or attempts tries were
+// made.
+func Retry(attempts int, delay time.Duration) Middleware {
+	return func(next OpFunc) OpFunc {
+		return func(op Op) error {
+			err := next(op)
+			for i := 1; i < attempts && err != nil && op.Wait(delay); i++ {
+				err = next(op)
+			}
+			return err
+		}
+	}
+}
+
+// Returns a middleware that passes every transaction, the time it took and
+// its error to log.
+func Tracing(log func(op Op, elapsed time.Duration, err error)) Middleware {
+	return func(next OpFunc) OpFunc {
+		return func(op Op) error {
+			start := time.Now()
+			err := next(op)
+			log(op, time.Since(start), err)
+			return err
+		}
+	}
+}
+
+// Returns a pacer that delays calls so they start at least interval apart.
+// The limit is shared by every handle the pacer is added to with UsePacer.
+// It applies to each transfer or sequence as a whole, and the transfers of
+// a sequence such as ReadPair still run back to back.
+func RateLimit(interval time.Duration) Pacer {
+	return &rateLimit{interval: interval}
+}
+
+type rateLimit struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     time.Time
+}
+
+func (l *rateLimit) Pace() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if wait := time.Until(l.last.Add(l.interval)); wait > 0 {
+		time.Sleep(wait)
+	}
+	l.last = time.Now()
+}
@@ -0,0 +1,186 @@
+package smbus
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	var calls []string
+	mark := func(name string) Middleware {
+		return func(next OpFunc) OpFunc {
+			return func(op Op) error {
+				calls = append(calls, name+" in "+op.Name)
+				err := next(op)
+				calls = append(calls, name+" out")
+				return err
+			}
+		}
+	}
+	smb.Use(mark("first"))
+	smb.Use(mark("second"))
+	if _, err := smb.Read_byte_data(0x10); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"first in read_byte_data", "second in read_byte_data", "second out", "first out"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls %q, want %q", calls, want)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.add(0x49)
+	limited := f.open(t, 0x48)
+	limited.UsePacer(RateLimit(50 * time.Millisecond))
+	other := f.open(t, 0x49)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := limited.Read_byte_data(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("three limited reads took %v, want at least 100ms", d)
+	}
+
+	// the limited handle waits without the bus lock
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, err := limited.Read_byte_data(0); err != nil {
+			t.Error(err)
+		}
+	}()
+	time.Sleep(10 * time.Millisecond)
+	start = time.Now()
+	if _, err := other.Read_byte_data(0); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 25*time.Millisecond {
+		t.Errorf("read on another handle took %v while the limited one waited", d)
+	}
+	<-done
+}
+
+func TestRetry(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+	failures := 2
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "read_byte_data" && failures > 0 {
+			failures--
+			return syscall.EIO
+		}
+		return nil
+	}
+
+	var traced []error
+	smb.Use(Retry(3, time.Millisecond), Tracing(func(op Op, elapsed time.Duration, err error) {
+		traced = append(traced, err)
+	}))
+	if _, err := smb.Read_byte_data(0x10); err != nil {
+		t.Fatal(err)
+	}
+	// Tracing is inside Retry, so it sees every attempt
+	if len(traced) != 3 || traced[0] == nil || traced[2] != nil {
+		t.Fatalf("traced %v, want two failures and a success", traced)
+	}
+
+	failures = 5
+	if _, err := smb.Read_byte_data(0x10); !errors.Is(err, syscall.EIO) {
+		t.Fatalf("got %v, want EIO after the attempts ran out", err)
+	}
+}
+
+// Retry waits for the next attempt without the bus lock, and gives up if
+// the handle moved to another address meanwhile
+func TestRetryWaitsWithoutLock(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.add(0x49)
+	retried := f.open(t, 0x48)
+	retried.Use(Retry(2, 50*time.Millisecond))
+	other := f.open(t, 0x49)
+	failures := 1
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "read_byte_data" && addr == 0x48 && failures > 0 {
+			failures--
+			return syscall.EIO
+		}
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := retried.Read_byte_data(0x10)
+		done <- err
+	}()
+	for f.count("read_byte_data 0x48") < 1 {
+		time.Sleep(time.Millisecond)
+	}
+	start := time.Now()
+	if _, err := other.Read_byte_data(0x10); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 25*time.Millisecond {
+		t.Errorf("read on another handle took %v while Retry waited", d)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("got %v, want the retry to succeed", err)
+	}
+
+	failures = 1
+	go func() {
+		_, err := retried.Read_byte_data(0x10)
+		done <- err
+	}()
+	for f.count("read_byte_data 0x48") < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := retried.Set_addr(0x49); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; !errors.Is(err, syscall.EIO) {
+		t.Fatalf("got %v, want the EIO of the attempt before the switch", err)
+	}
+	if n := f.count("read_byte_data 0x49"); n != 1 {
+		t.Fatalf("%d reads at 0x49, want only the other handle's", n)
+	}
+}
+
+// Use builds the chain without the bus lock, so a middleware constructor
+// can use another handle on the bus
+func TestUseBuildsWithoutLock(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.add(0x49)
+	smb := f.open(t, 0x48)
+	other := f.open(t, 0x49)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		smb.Use(func(next OpFunc) OpFunc {
+			if _, err := other.Read_byte_data(0x00); err != nil {
+				t.Error(err)
+			}
+			return next
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Use held the bus lock while building the chain")
+	}
+}
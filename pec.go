@@ -0,0 +1,58 @@
+package smbus
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returned by Read_block_data_pec when the checksum sent by the device does
+// not match the data
+var ErrPECMismatch = errors.New("PEC mismatch")
+
+// Computes the SMBus Packet Error Code of data: a CRC-8 with polynomial
+// x^8 + x^2 + x + 1 (0x07) and an initial value of 0. For a transfer, data
+// is every byte on the wire, starting with the address byte(s).
+func PEC(data []byte) byte {
+	var crc byte
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x07
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Reads len(buf) bytes from register cmd followed by the PEC byte the
+// device appends, with a plain i2c block read, and checks the PEC in
+// software. This suits adapters that cannot do PEC themselves. The PEC
+// covers the write address, cmd, the read address and the data. Returns
+// an error wrapping ErrPECMismatch if the check fails. buf may hold at most
+// 31 bytes, and only 7-bit addresses are supported.
+func (smb *SMBus) Read_block_data_pec(cmd byte, buf []byte) (int, error) {
+	if len(buf) == 0 || len(buf) > 31 {
+		return 0, fmt.Errorf("Buffer must hold 1 to 31 bytes, got %d", len(buf))
+	}
+	raw := make([]byte, len(buf)+1)
+	defer smb.rlock()()
+	if smb.tenbit {
+		return 0, errors.New("PEC checking needs a 7-bit address")
+	}
+	n, err := smb.readI2CBlockData(cmd, raw)
+	if err != nil {
+		return 0, err
+	}
+	if n != len(raw) {
+		return 0, fmt.Errorf("Short block read: got %d of %d bytes", n, len(raw))
+	}
+	addr := byte(smb.addr) << 1
+	msg := append([]byte{addr, cmd, addr | 1}, raw[:len(buf)]...)
+	if want, got := PEC(msg), raw[len(buf)]; got != want {
+		return 0, fmt.Errorf("%w: got 0x%02X, expected 0x%02X", ErrPECMismatch, got, want)
+	}
+	return copy(buf, raw), nil
+}
@@ -0,0 +1,47 @@
+package smbus
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPEC(t *testing.T) {
+	for _, tc := range []struct {
+		data []byte
+		want byte
+	}{
+		{nil, 0x00},
+		{[]byte{0x01}, 0x07},
+		{[]byte{0x80}, 0x89},
+		// the CRC-8 check value
+		{[]byte("123456789"), 0xF4},
+	} {
+		if got := PEC(tc.data); got != tc.want {
+			t.Errorf("PEC(% X) = 0x%02X, want 0x%02X", tc.data, got, tc.want)
+		}
+	}
+}
+
+func TestReadBlockDataPEC(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	data := []byte{0x12, 0x34, 0x56}
+	copy(d.regs[0x20:], data)
+	d.regs[0x23] = PEC(append([]byte{0x90, 0x20, 0x91}, data...))
+	smb := f.open(t, 0x48)
+
+	buf := make([]byte, 3)
+	n, err := smb.Read_block_data_pec(0x20, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], data) {
+		t.Fatalf("read % X", buf[:n])
+	}
+
+	f.setReg(0x48, 0x23, d.regs[0x23]^0x01)
+	if _, err := smb.Read_block_data_pec(0x20, buf); !errors.Is(err, ErrPECMismatch) {
+		t.Fatalf("got %v, want ErrPECMismatch", err)
+	}
+}
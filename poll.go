@@ -0,0 +1,377 @@
+package smbus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Returned by the waiting helpers when the condition was not met in time
+var ErrTimeout = errors.New("Timed out")
+
+// Returned by Poll_byte_data when the predicate did not hold in time. It is
+// a distinct value, but errors.Is(ErrPollTimeout, ErrTimeout) holds.
+var ErrPollTimeout error = pollTimeout{}
+
+type pollTimeout struct{}
+
+func (pollTimeout) Error() string {
+	return "Poll timed out"
+}
+
+func (pollTimeout) Is(target error) bool {
+	return target == ErrTimeout
+}
+
+// Calls try every interval until it reports done or fails, and once more
+// at the deadline, timeout from now, so the whole timeout is used whether
+// or not it is a multiple of interval. Returns try's error, or ErrTimeout
+// if try was not done by the deadline.
+func pollUntil(timeout, interval time.Duration, try func() (bool, error)) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		done, err := try()
+		if err != nil || done {
+			return err
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return ErrTimeout
+		}
+		if wait > interval {
+			wait = interval
+		}
+		time.Sleep(wait)
+	}
+}
+
+// A timestamped register read delivered by the sampling helpers
+type SampleResult struct {
+	Time  time.Time
+	Value byte
+	Err   error
+}
+
+// Samples a register every period on a channel, with the first sample taken
+// on the next multiple of period (e.g. on the full second for a one second
+// period). Each sample is scheduled relative to that boundary rather than to
+// the previous sample, so read latency does not accumulate into drift;
+// boundaries that have already passed are skipped. Samples are stamped with
+// their boundary, giving evenly spaced timestamps for charting. Read errors
+// are delivered in SampleResult.Err. The channel is closed when ctx is done.
+func (smb *SMBus) SampleAligned(cmd byte, period time.Duration, ctx context.Context) <-chan SampleResult {
+	out := make(chan SampleResult)
+	go func() {
+		defer close(out)
+		if period <= 0 {
+			select {
+			case out <- SampleResult{Time: time.Now(), Err: errors.New("Sampling period must be positive")}:
+			case <-ctx.Done():
+			}
+			return
+		}
+		next := time.Now().Truncate(period).Add(period)
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			val, err := smb.Read_byte_data(cmd)
+			res := SampleResult{Time: next, Value: val, Err: err}
+			select {
+			case out <- res:
+			case <-ctx.Done():
+				return
+			}
+			now := time.Now()
+			for !next.After(now) {
+				next = next.Add(period)
+			}
+			timer.Reset(time.Until(next))
+		}
+	}()
+	return out
+}
+
+// A register value and the time it was read
+type TimedSample struct {
+	Time  time.Time
+	Value byte
+}
+
+// Reads a register every interval for duration and returns the samples in
+// the order they were taken. If ctx is done or a read fails first, the
+// samples captured so far are returned together with ctx.Err() or the
+// read error.
+func (smb *SMBus) CaptureTimed(cmd byte, duration, interval time.Duration, ctx context.Context) ([]TimedSample, error) {
+	if interval <= 0 {
+		return nil, errors.New("Capture interval must be positive")
+	}
+	if duration < 0 {
+		return nil, errors.New("Capture duration must not be negative")
+	}
+	var samples []TimedSample
+	end := time.Now().Add(duration)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		val, err := smb.Read_byte_data(cmd)
+		if err != nil {
+			return samples, err
+		}
+		samples = append(samples, TimedSample{Time: time.Now(), Value: val})
+		if !time.Now().Add(interval).Before(end) {
+			return samples, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return samples, ctx.Err()
+		}
+	}
+}
+
+// Reads a register until it returns the same value stableReads times in a
+// row, waiting delay between reads, and returns that value. The count starts
+// over whenever the value changes. Gives up with an error after ten times
+// stableReads reads without settling.
+func (smb *SMBus) ReadDebounced(cmd byte, stableReads int, delay time.Duration) (byte, error) {
+	if stableReads < 1 {
+		return 0, errors.New("Stable read count must be at least 1")
+	}
+	var last byte
+	stable := 0
+	for i := 0; i < 10*stableReads; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		val, err := smb.Read_byte_data(cmd)
+		if err != nil {
+			return 0, err
+		}
+		if stable > 0 && val == last {
+			stable++
+		} else {
+			last, stable = val, 1
+		}
+		if stable == stableReads {
+			return val, nil
+		}
+	}
+	return 0, errors.New("Register value did not settle")
+}
+
+// Sets the commit (latch) bit commitBit in a register and waits for the
+// device to clear it again, which signals that the operation was applied.
+// The register is always written, even if the bit still reads as set from
+// an earlier commit, and the read and write run under one lock. It is then
+// polled every interval until the bit clears or timeout elapses, in which
+// case ErrTimeout is returned.
+func (smb *SMBus) CommitAndWait(cmd byte, commitBit uint, timeout, interval time.Duration) error {
+	if commitBit > 7 {
+		return errors.New("Bit index must be between 0 and 7")
+	}
+	mask := byte(1) << commitBit
+	if err := smb.strobe(cmd, mask); err != nil {
+		return err
+	}
+	return smb.waitBits(cmd, mask, 0, timeout, interval)
+}
+
+// Reads register cmd and writes it back with the bits in mask set
+func (smb *SMBus) strobe(cmd, mask byte) error {
+	defer smb.lock()()
+	val, err := smb.readByteData(cmd)
+	if err != nil {
+		return err
+	}
+	return smb.writeByteData(cmd, val|mask)
+}
+
+// Polls register cmd every interval until the bits in mask equal want, or
+// returns ErrTimeout once timeout has elapsed.
+func (smb *SMBus) waitBits(cmd, mask, want byte, timeout, interval time.Duration) error {
+	_, err := smb.Poll_byte_data(cmd, func(val byte) bool { return val&mask == want }, interval, timeout)
+	if err == ErrPollTimeout {
+		return ErrTimeout
+	}
+	return err
+}
+
+// Reads register cmd every interval until predicate returns true for the
+// value, and returns that value. Read errors are returned at once. The
+// register is read a last time when timeout elapses; if predicate still
+// does not hold, that value is returned with ErrPollTimeout.
+func (smb *SMBus) Poll_byte_data(cmd byte, predicate func(byte) bool, interval time.Duration, timeout time.Duration) (byte, error) {
+	var val byte
+	err := pollUntil(timeout, interval, func() (bool, error) {
+		v, err := smb.Read_byte_data(cmd)
+		if err != nil {
+			return false, err
+		}
+		val = v
+		return predicate(v), nil
+	})
+	switch {
+	case err == ErrTimeout:
+		return val, ErrPollTimeout
+	case err != nil:
+		return 0, err
+	}
+	return val, nil
+}
+
+// Writes value to writeCmd, then polls the status register statusCmd every
+// interval until bit flagBit is set, signalling that the device completed
+// the write. Returns ErrTimeout if the flag is not set within timeout.
+func (smb *SMBus) WriteAndAwaitFlag(writeCmd, value, statusCmd byte, flagBit uint, timeout, interval time.Duration) error {
+	if flagBit > 7 {
+		return errors.New("Bit index must be between 0 and 7")
+	}
+	if err := smb.Write_byte_data(writeCmd, value); err != nil {
+		return err
+	}
+	mask := byte(1) << flagBit
+	return smb.waitBits(statusCmd, mask, mask, timeout, interval)
+}
+
+// Probes addr with a Receive Byte every interval until the device answers,
+// returning nil, or until timeout elapses, returning ErrTimeout. The last
+// probe is made when timeout elapses. The handle's own address is restored
+// after every probe.
+func (smb *SMBus) WaitForDevice(addr byte, timeout, interval time.Duration) error {
+	return pollUntil(timeout, interval, func() (bool, error) {
+		return smb.probe(addr), nil
+	})
+}
+
+// Interval at which ResetBus probes for returning devices
+const resetProbeInterval = 5 * time.Millisecond
+
+// Sends resetByte to the general call address 0x00, 0x06 being the
+// standard reset, then waits until each device in addrs acknowledges a
+// Receive Byte again. All devices share the settle period; the error names
+// every device that did not come back within it. The handle's own address
+// is restored afterwards.
+func (smb *SMBus) ResetBus(resetByte byte, addrs []byte, settle time.Duration) error {
+	err := func() error {
+		defer smb.lock()()
+		return smb.withAddr(0x00, func() error {
+			return smb.writeByte(resetByte)
+		})
+	}()
+	if err != nil {
+		return fmt.Errorf("General call reset failed: %w", err)
+	}
+	deadline := time.Now().Add(settle)
+	var missing []string
+	for _, addr := range addrs {
+		if err := smb.WaitForDevice(addr, time.Until(deadline), resetProbeInterval); err != nil {
+			missing = append(missing, fmt.Sprintf("0x%02X", addr))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("Devices did not return after reset: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Reports whether the device at addr acknowledges a Receive Byte
+func (smb *SMBus) probe(addr byte) bool {
+	defer smb.lock()()
+	return smb.withAddr(addr, func() error {
+		_, err := smb.readByte()
+		return err
+	}) == nil
+}
+
+// Reads a register every interval and sends the values to out until ctx is
+// done, returning ctx.Err(), or a read fails, returning the error. If out
+// is full, the value is dropped and counted in Stats().Dropped when
+// dropOnFull is set; otherwise Stream waits for the consumer.
+func (smb *SMBus) Stream(cmd byte, interval time.Duration, out chan<- byte, dropOnFull bool, ctx context.Context) error {
+	if interval <= 0 {
+		return errors.New("Stream interval must be positive")
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		val, err := smb.Read_byte_data(cmd)
+		if err != nil {
+			return err
+		}
+		if dropOnFull {
+			select {
+			case out <- val:
+			default:
+				smb.dropped.Add(1)
+			}
+		} else {
+			select {
+			case out <- val:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Reads a register every interval and calls onCross with the value each
+// time it crosses threshold: upwards, reaching threshold or above, if
+// rising is set, and downwards, reaching threshold or below, otherwise. A
+// value already past threshold when watching starts does not count. After
+// a crossing, the value must move back past threshold by more than
+// hysteresis before another crossing is reported, so noise around the
+// threshold does not cause repeated calls. Runs until ctx is done,
+// returning ctx.Err(), or a read fails, returning the error.
+func (smb *SMBus) WatchThreshold(cmd byte, threshold, hysteresis byte, rising bool, interval time.Duration, ctx context.Context, onCross func(value byte)) error {
+	if interval <= 0 {
+		return errors.New("Watch interval must be positive")
+	}
+	crossed := func(v byte) bool {
+		if rising {
+			return v >= threshold
+		}
+		return v <= threshold
+	}
+	rearm := func(v byte) bool {
+		if rising {
+			return int(v) < int(threshold)-int(hysteresis)
+		}
+		return int(v) > int(threshold)+int(hysteresis)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	first, armed := true, false
+	for {
+		val, err := smb.Read_byte_data(cmd)
+		if err != nil {
+			return err
+		}
+		switch {
+		case first:
+			armed = !crossed(val)
+			first = false
+		case armed && crossed(val):
+			armed = false
+			onCross(val)
+		case !armed && rearm(val):
+			armed = true
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package smbus
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSampleAligned(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x10] = 0x42
+	smb := f.open(t, 0x48)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const period = 20 * time.Millisecond
+	samples := smb.SampleAligned(0x10, period, ctx)
+	var prev time.Time
+	for i := 0; i < 3; i++ {
+		s := <-samples
+		if s.Err != nil || s.Value != 0x42 {
+			t.Fatalf("sample %d: 0x%02X, %v", i, s.Value, s.Err)
+		}
+		if !s.Time.Truncate(period).Equal(s.Time) {
+			t.Fatalf("sample %d stamped %v, not on a %v boundary", i, s.Time, period)
+		}
+		if time.Now().Before(s.Time) {
+			t.Fatalf("sample %d delivered before its boundary", i)
+		}
+		if i > 0 && s.Time.Sub(prev)%period != 0 {
+			t.Fatalf("samples %v apart", s.Time.Sub(prev))
+		}
+		prev = s.Time
+	}
+	cancel()
+	for range samples {
+	}
+}
+
+func TestReadDebounced(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	seq := []byte{0x01, 0x03, 0x03, 0x02, 0x07, 0x07, 0x07}
+	reads := 0
+	d.onRead = func(reg byte) (byte, error) {
+		v := seq[len(seq)-1]
+		if reads < len(seq) {
+			v = seq[reads]
+		}
+		reads++
+		return v, nil
+	}
+	smb := f.open(t, 0x48)
+
+	v, err := smb.ReadDebounced(0x10, 3, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0x07 || reads != len(seq) {
+		t.Fatalf("got 0x%02X after %d reads, want 0x07 after %d", v, reads, len(seq))
+	}
+
+	reads = 0
+	d.onRead = func(reg byte) (byte, error) {
+		reads++
+		return byte(reads), nil
+	}
+	if _, err := smb.ReadDebounced(0x10, 2, 0); err == nil {
+		t.Fatal("a value that never settles was accepted")
+	}
+	if reads != 20 {
+		t.Fatalf("gave up after %d reads, want 20", reads)
+	}
+}
+
+// Makes bit of register reg read as set for the next n reads after it was
+// written as set
+func clearsAfter(d *fakeDev, reg byte, bit uint, n int) {
+	left := 0
+	d.onWrite = func(r, v byte) error {
+		if r == reg && v&(1<<bit) != 0 {
+			left = n
+		}
+		d.regs[r] = v
+		return nil
+	}
+	d.onRead = func(r byte) (byte, error) {
+		v := d.regs[r]
+		if r == reg && v&(1<<bit) != 0 {
+			if left == 0 {
+				v &^= 1 << bit
+				d.regs[r] = v
+			} else {
+				left--
+			}
+		}
+		return v, nil
+	}
+}
+
+func TestCommitAndWait(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.regs[0x20] = 0x05
+	// the first three polls see the bit set
+	clearsAfter(d, 0x20, 7, 3)
+	smb := f.open(t, 0x48)
+
+	if err := smb.CommitAndWait(0x20, 7, time.Second, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("write_byte_data"); n != 1 {
+		t.Fatalf("%d writes, want 1", n)
+	}
+	if got := f.reg(0x48, 0x20); got != 0x05 {
+		t.Fatalf("register holds 0x%02X after commit, want 0x05", got)
+	}
+}
+
+// A commit while the bit still reads as set from an earlier one strobes
+// the device again
+func TestCommitAndWaitBitLatched(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x20] = 0x85
+	smb := f.open(t, 0x48)
+
+	err := smb.CommitAndWait(0x20, 7, 20*time.Millisecond, 5*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	if n := f.count("write_byte_data"); n != 1 {
+		t.Fatalf("%d writes, want the commit bit written again", n)
+	}
+	if err := smb.CommitAndWait(0x20, 8, time.Second, time.Millisecond); err == nil {
+		t.Fatal("bit 8 was accepted")
+	}
+}
+
+func TestCommitAndWaitNeverClears(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	err := smb.CommitAndWait(0x20, 0, 30*time.Millisecond, 5*time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+}
+
+// Makes the device at addr ignore the first n Receive Byte probes
+func nakFirst(f *fakeBus, addr uint16, n int) {
+	f.fail = func(op string, a uint16, cmd byte) error {
+		if op == "read_byte" && a == addr && n > 0 {
+			n--
+			return syscall.ENXIO
+		}
+		return nil
+	}
+}
+
+func TestWaitForDevice(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.add(0x50)
+	nakFirst(f, 0x50, 3)
+	smb := f.open(t, 0x48)
+
+	if err := smb.WaitForDevice(0x50, time.Second, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("read_byte"); n != 4 {
+		t.Fatalf("%d probes, want 3 NAKs and 1 ACK", n)
+	}
+	// the handle still talks to its own device
+	f.setReg(0x48, 0x01, 0x99)
+	if v, err := smb.Read_byte_data(0x01); err != nil || v != 0x99 {
+		t.Fatalf("read after waiting: 0x%02X, %v", v, err)
+	}
+
+	start := time.Now()
+	if err := smb.WaitForDevice(0x51, 30*time.Millisecond, 20*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("got %v for a missing device, want ErrTimeout", err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("gave up after %v, before the timeout", d)
+	}
+}
+
+func TestStreamDropsForSlowConsumer(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	if err := smb.Stream(0x00, 0, make(chan byte), true, context.Background()); err == nil {
+		t.Fatal("Stream accepted a zero interval")
+	}
+
+	// nobody reads from out, so every sample after the first is dropped
+	out := make(chan byte, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := smb.Stream(0x00, time.Millisecond, out, true, ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Stream returned %v, want the context error", err)
+	}
+	reads := f.count("read_byte_data")
+	if got := smb.Stats().Dropped; got == 0 || got != uint64(reads-1) {
+		t.Fatalf("dropped %d of %d samples, want all but the first", got, reads)
+	}
+}
+
+func TestWriteAndAwaitFlag(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x50)
+	// the ready flag, bit 0 of register 0x07, sets on poll setOn; never if
+	// setOn is 0
+	polls, setOn := 0, 3
+	d.onRead = func(reg byte) (byte, error) {
+		if reg == 0x07 {
+			polls++
+			if setOn > 0 && polls >= setOn {
+				return 0x01, nil
+			}
+			return 0x00, nil
+		}
+		return d.regs[reg], nil
+	}
+	smb := f.open(t, 0x50)
+
+	if err := smb.WriteAndAwaitFlag(0x10, 0xAB, 0x07, 0, time.Second, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if f.reg(0x50, 0x10) != 0xAB || polls != 3 {
+		t.Fatalf("wrote 0x%02X and polled %d times, want 0xAB and 3 polls", f.reg(0x50, 0x10), polls)
+	}
+
+	setOn = 0
+	err := smb.WriteAndAwaitFlag(0x10, 0xAB, 0x07, 0, 20*time.Millisecond, time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+}
+
+func TestResetBus(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x00)
+	f.add(0x48)
+	f.add(0x49)
+	f.add(0x4A)
+	// 0x49 takes a few probes to come back, 0x4A never does
+	busy49 := 3
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		switch {
+		case op == "read_byte" && addr == 0x49 && busy49 > 0:
+			busy49--
+			return syscall.ENXIO
+		case op == "read_byte" && addr == 0x4A:
+			return syscall.ENXIO
+		}
+		return nil
+	}
+	smb := f.open(t, 0x48)
+
+	err := smb.ResetBus(0x06, []byte{0x48, 0x49, 0x4A}, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("a device that never came back was not reported")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "0x4A") || strings.Contains(msg, "0x48") || strings.Contains(msg, "0x49") {
+		t.Fatalf("got %q, want only 0x4A named", msg)
+	}
+	if n := f.count("write_byte 0x00 0x06"); n != 1 {
+		t.Fatalf("general call reset sent %d times, want once", n)
+	}
+	if smb.addr != 0x48 {
+		t.Fatalf("handle left on 0x%02X", smb.addr)
+	}
+}
+
+func TestCaptureTimed(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	next := byte(0)
+	d.onRead = func(reg byte) (byte, error) {
+		next++
+		return next, nil
+	}
+	smb := f.open(t, 0x48)
+
+	samples, err := smb.CaptureTimed(0x10, 100*time.Millisecond, 10*time.Millisecond, context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// one sample at the start and one per tick, none after 100ms
+	if len(samples) < 8 || len(samples) > 10 {
+		t.Fatalf("%d samples in 100ms at 10ms, want about 10", len(samples))
+	}
+	for i := 1; i < len(samples); i++ {
+		if !samples[i].Time.After(samples[i-1].Time) || samples[i].Value != samples[i-1].Value+1 {
+			t.Fatalf("sample %d out of order: %+v after %+v", i, samples[i], samples[i-1])
+		}
+	}
+
+	if _, err := smb.CaptureTimed(0x10, -time.Second, 10*time.Millisecond, context.Background()); err == nil {
+		t.Fatal("a negative duration was accepted")
+	}
+	// a long capture at a short interval does not allocate for every
+	// sample it could take up front
+	ctx, cancel := context.WithTimeout(context.Background(), 25*time.Millisecond)
+	defer cancel()
+	samples, err = smb.CaptureTimed(0x10, 1<<62, time.Nanosecond, ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || len(samples) == 0 {
+		t.Fatalf("got %d samples, %v, want the samples before ctx expired", len(samples), err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 25*time.Millisecond)
+	defer cancel()
+	samples, err = smb.CaptureTimed(0x10, time.Second, 10*time.Millisecond, ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || len(samples) == 0 || len(samples) > 4 {
+		t.Fatalf("got %d samples, %v, want the samples before ctx expired", len(samples), err)
+	}
+}
+
+func TestWatchThreshold(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	// rises through 50, wobbles around it within the hysteresis, drops
+	// well below and stays there
+	values := []byte{40, 45, 52, 49, 51, 48, 30, 30}
+	i := 0
+	d.onRead = func(r byte) (byte, error) {
+		v := values[len(values)-1]
+		if i < len(values) {
+			v = values[i]
+			i++
+		}
+		return v, nil
+	}
+	smb := f.open(t, 0x48)
+
+	if err := smb.WatchThreshold(0x00, 50, 5, true, 0, context.Background(), func(byte) {}); err == nil {
+		t.Fatal("WatchThreshold accepted a zero interval")
+	}
+
+	var crossings []byte
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	smb.WatchThreshold(0x00, 50, 5, true, time.Millisecond, ctx, func(v byte) {
+		crossings = append(crossings, v)
+	})
+	if len(crossings) != 1 || crossings[0] != 52 {
+		t.Fatalf("crossings %v, want a single one at 52", crossings)
+	}
+}
+
+func TestPollByteData(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	reads := 0
+	d.onRead = func(r byte) (byte, error) {
+		reads++
+		return byte(reads), nil
+	}
+	smb := f.open(t, 0x48)
+
+	val, err := smb.Poll_byte_data(0x00, func(v byte) bool { return v >= 3 }, time.Millisecond, time.Second)
+	if err != nil || val != 3 || reads != 3 {
+		t.Fatalf("got 0x%02X, %v after %d reads, want 3 after 3 reads", val, err, reads)
+	}
+
+	// on timeout the last value read comes with ErrPollTimeout
+	val, err = smb.Poll_byte_data(0x00, func(v byte) bool { return false }, 5*time.Millisecond, 20*time.Millisecond)
+	if !errors.Is(err, ErrPollTimeout) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrPollTimeout matching ErrTimeout", err)
+	}
+	if int(val) != reads {
+		t.Fatalf("got 0x%02X, want the last value read, 0x%02X", val, reads)
+	}
+}
+
+func TestPollByteDataUsesWholeTimeout(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	// the value becomes ready just before the 30ms deadline, which is not
+	// a multiple of the 20ms interval
+	ready := time.Now().Add(25 * time.Millisecond)
+	d.onRead = func(r byte) (byte, error) {
+		if time.Now().After(ready) {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	smb := f.open(t, 0x48)
+
+	val, err := smb.Poll_byte_data(0x00, func(v byte) bool { return v == 1 }, 20*time.Millisecond, 30*time.Millisecond)
+	if err != nil || val != 1 {
+		t.Fatalf("got 0x%02X, %v; want the value read at the deadline", val, err)
+	}
+}
+
+func TestPollByteDataTimeout(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x00] = 0x42
+	smb := f.open(t, 0x48)
+
+	start := time.Now()
+	val, err := smb.Poll_byte_data(0x00, func(v byte) bool { return false }, 20*time.Millisecond, 30*time.Millisecond)
+	if err != ErrPollTimeout || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrPollTimeout matching ErrTimeout", err)
+	}
+	if val != 0x42 {
+		t.Fatalf("got 0x%02X, want the last value read", val)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("gave up after %v, before the timeout", d)
+	}
+	if ErrPollTimeout == ErrTimeout {
+		t.Fatal("ErrPollTimeout is the same value as ErrTimeout")
+	}
+}
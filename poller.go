@@ -0,0 +1,135 @@
+package smbus
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Polls registers on many handles with a fixed number of worker goroutines,
+// however many registers are polled. Create one with NewPoller.
+type Poller struct {
+	add  chan *pollJob
+	jobs chan *pollJob
+	stop chan struct{}
+	once sync.Once
+	wg   sync.WaitGroup
+}
+
+type pollJob struct {
+	smb      *SMBus
+	cmd      byte
+	interval time.Duration
+	handler  func(byte, error)
+	next     time.Time
+}
+
+// Poll jobs ordered by when they are due next
+type pollQueue []*pollJob
+
+func (q pollQueue) Len() int            { return len(q) }
+func (q pollQueue) Less(i, j int) bool  { return q[i].next.Before(q[j].next) }
+func (q pollQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *pollQueue) Push(x interface{}) { *q = append(*q, x.(*pollJob)) }
+func (q *pollQueue) Pop() interface{} {
+	old := *q
+	j := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return j
+}
+
+// Creates a poller that runs polls on workers goroutines. A count below 1
+// is treated as 1.
+func NewPoller(workers int) *Poller {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Poller{
+		add:  make(chan *pollJob),
+		jobs: make(chan *pollJob),
+		stop: make(chan struct{}),
+	}
+	p.wg.Add(workers + 1)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	go p.schedule()
+	return p
+}
+
+// Reads register cmd of smb every interval, starting right away, and
+// passes the value or error to handler. handler runs on a worker goroutine,
+// so a slow handler delays other polls. When every worker is busy, due
+// polls wait for one to become free, and polls that fall behind skip the
+// intervals they missed. Does nothing after Stop or if interval is not
+// positive.
+func (p *Poller) Add(smb *SMBus, cmd byte, interval time.Duration, handler func(byte, error)) {
+	if interval <= 0 {
+		return
+	}
+	j := &pollJob{smb: smb, cmd: cmd, interval: interval, handler: handler, next: time.Now()}
+	select {
+	case p.add <- j:
+	case <-p.stop:
+	}
+}
+
+// Stops polling and waits for running handlers to return. Calling Stop
+// more than once is safe.
+func (p *Poller) Stop() {
+	p.once.Do(func() { close(p.stop) })
+	p.wg.Wait()
+}
+
+func (p *Poller) work() {
+	defer p.wg.Done()
+	for {
+		select {
+		case j := <-p.jobs:
+			j.handler(j.smb.Read_byte_data(j.cmd))
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// Hands due jobs to the workers and reschedules them
+func (p *Poller) schedule() {
+	defer p.wg.Done()
+	var q pollQueue
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		if len(q) > 0 {
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(time.Until(q[0].next))
+		}
+		select {
+		case j := <-p.add:
+			heap.Push(&q, j)
+			continue
+		case <-timer.C:
+		case <-p.stop:
+			return
+		}
+		now := time.Now()
+		for len(q) > 0 && !q[0].next.After(now) {
+			j := heap.Pop(&q).(*pollJob)
+			select {
+			case p.jobs <- j:
+			case <-p.stop:
+				return
+			}
+			j.next = j.next.Add(j.interval)
+			if now := time.Now(); !j.next.After(now) {
+				j.next = now.Add(j.interval)
+			}
+			heap.Push(&q, j)
+		}
+	}
+}
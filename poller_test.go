@@ -0,0 +1,52 @@
+package smbus
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPollerWorkers(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	var mu sync.Mutex
+	active, peak := 0, 0
+	calls := make(map[byte]int)
+	p := NewPoller(2)
+	for cmd := byte(0); cmd < 6; cmd++ {
+		p.Add(smb, cmd, 10*time.Millisecond, func(cmd byte) func(byte, error) {
+			return func(v byte, err error) {
+				if err != nil {
+					t.Error(err)
+				}
+				mu.Lock()
+				active++
+				if active > peak {
+					peak = active
+				}
+				calls[cmd]++
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				active--
+				mu.Unlock()
+			}
+		}(cmd))
+	}
+	time.Sleep(100 * time.Millisecond)
+	p.Stop()
+	p.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if peak != 2 {
+		t.Fatalf("at most %d handlers ran at once, want the 2 workers", peak)
+	}
+	for cmd := byte(0); cmd < 6; cmd++ {
+		if calls[cmd] == 0 {
+			t.Fatalf("register 0x%02X was never polled", cmd)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package smbus
+
+/*
+#include "i2c-dev.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Submits msgs as one combined I2C_RDWR transaction, with a repeated start
+// between messages and a single stop at the end. Returns an error if the
+// adapter processed fewer messages than were submitted. read, if not nil,
+// returns the bytes read, for the Trace hook and the history.
+func (smb *SMBus) rdwr(msgs []i2cMsg, read func() []byte) error {
+	return smb.transact("rdwr", 0, func() error {
+		n, err := smb.tr.rdwr(smb.bus.Fd(), msgs)
+		if err != nil {
+			return err
+		}
+		if n != len(msgs) {
+			return fmt.Errorf("Adapter processed %d of %d messages", n, len(msgs))
+		}
+		return nil
+	}, read)
+}
+
+// Builds an i2c_msg addressed to the handle's device
+func (smb *SMBus) msg(flags uint16, buf []byte) i2cMsg {
+	if smb.tenbit {
+		flags |= i2c_M_TEN
+	}
+	return i2cMsg{addr: smb.addr, flags: flags, buf: buf}
+}
+
+// Writes w to the device and then reads len(r) bytes into r in a single
+// combined transaction, with a repeated start instead of a stop between
+// the two. Many sensors need this to read from a register pointer. Returns
+// the number of bytes read.
+func (smb *SMBus) WriteRead(w []byte, r []byte) (int, error) {
+	if len(w) == 0 || len(r) == 0 {
+		return 0, errors.New("Write and read buffers must not be empty")
+	}
+	defer smb.lock()()
+	if err := smb.rdwr([]i2cMsg{smb.msg(0, w), smb.msg(i2c_M_RD, r)}, func() []byte { return r }); err != nil {
+		return 0, err
+	}
+	return len(r), nil
+}
+
+// Reads a variable-length block from a device that sends a length byte
+// followed by that many data bytes, and returns the data. The length is
+// read first with Read_byte_data, then the length byte and data are read in
+// one transfer. That is an I2C_RDWR read if the adapter supports plain i2c
+// transfers, which allows up to 255 data bytes, and an i2c block read
+// otherwise, which allows up to 31. A length of 0 is an error.
+func (smb *SMBus) Read_i2c_block_len(cmd byte) ([]byte, error) {
+	f, err := smb.funcs()
+	if err != nil {
+		return nil, err
+	}
+	defer smb.rlock()()
+	n, err := smb.readByteData(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errors.New("Device reported an empty block")
+	}
+	buf := make([]byte, int(n)+1)
+	if f&FUNC_I2C != 0 {
+		cmdBuf := []byte{cmd}
+		if err := smb.rdwr([]i2cMsg{smb.msg(0, cmdBuf), smb.msg(i2c_M_RD, buf)}, func() []byte { return buf }); err != nil {
+			return nil, err
+		}
+	} else {
+		if len(buf) > 32 {
+			return nil, fmt.Errorf("Device reported %d bytes, the adapter can read at most 31", n)
+		}
+		got, err := smb.readI2CBlockData(cmd, buf)
+		if err != nil {
+			return nil, err
+		}
+		if got != len(buf) {
+			return nil, fmt.Errorf("Short block read: got %d of %d bytes", got, len(buf))
+		}
+	}
+	if buf[0] != n {
+		return nil, fmt.Errorf("Block length changed from %d to %d between reads", n, buf[0])
+	}
+	return buf[1:], nil
+}
+
+// Reads several, not necessarily adjacent, byte registers. If the adapter
+// supports plain i2c transfers, each register is read with a write of the
+// command byte followed by a one byte read, and all of them are submitted in
+// as few I2C_RDWR ioctls as possible. Otherwise the registers are read one
+// by one with Read_byte_data. The values are returned in the order of cmds.
+func (smb *SMBus) ReadBytesBatch(cmds []byte) ([]byte, error) {
+	out := make([]byte, len(cmds))
+	if len(cmds) == 0 {
+		return out, nil
+	}
+	f, err := smb.funcs()
+	if err != nil {
+		return nil, err
+	}
+	defer smb.rlock()()
+	if f&FUNC_I2C == 0 {
+		for i, cmd := range cmds {
+			if out[i], err = smb.readByteData(cmd); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}
+	// every register takes two messages
+	const perCall = C.I2C_RDRW_IOCTL_MAX_MSGS / 2
+	for off := 0; off < len(cmds); off += perCall {
+		end := off + perCall
+		if end > len(cmds) {
+			end = len(cmds)
+		}
+		msgs := make([]i2cMsg, 0, 2*(end-off))
+		for i := off; i < end; i++ {
+			msgs = append(msgs, smb.msg(0, cmds[i:i+1]), smb.msg(i2c_M_RD, out[i:i+1]))
+		}
+		if err := smb.rdwr(msgs, func() []byte { return out[off:end] }); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,105 @@
+package smbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReadBytesBatch(t *testing.T) {
+	for _, batched := range []bool{true, false} {
+		f := newFakeBus(t)
+		d := f.add(0x48)
+		d.regs[0x01], d.regs[0x20], d.regs[0x07] = 0xA1, 0xB2, 0xC3
+		if !batched {
+			f.funcMask &^= FUNC_I2C
+		}
+		smb := f.open(t, 0x48)
+
+		got, err := smb.ReadBytesBatch([]byte{0x01, 0x20, 0x07})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, []byte{0xA1, 0xB2, 0xC3}) {
+			t.Fatalf("batched %v: got % X", batched, got)
+		}
+		rdwr, single := f.count("rdwr"), f.count("read_byte_data")
+		if batched && (rdwr != 1 || single != 0) {
+			t.Fatalf("%d I2C_RDWR calls and %d byte reads, want one I2C_RDWR", rdwr, single)
+		}
+		if !batched && (rdwr != 0 || single != 3) {
+			t.Fatalf("%d I2C_RDWR calls and %d byte reads without plain i2c support, want 3 byte reads", rdwr, single)
+		}
+	}
+}
+
+func TestWriteRead(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	copy(d.regs[0x20:], []byte{0x11, 0x22, 0x33})
+	smb := f.open(t, 0x48)
+	start := len(f.ops())
+
+	r := make([]byte, 3)
+	n, err := smb.WriteRead([]byte{0x20}, r)
+	if err != nil || n != 3 {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	if !bytes.Equal(r, []byte{0x11, 0x22, 0x33}) {
+		t.Fatalf("read % X", r)
+	}
+	// one combined transaction of a write and a read
+	if ops := f.ops()[start:]; len(ops) != 1 || ops[0] != "rdwr 2" {
+		t.Fatalf("ops %q, want a single two-message I2C_RDWR", ops)
+	}
+
+	if _, err := smb.WriteRead(nil, r); err == nil {
+		t.Error("empty write buffer accepted")
+	}
+	if _, err := smb.WriteRead([]byte{0x20}, nil); err == nil {
+		t.Error("empty read buffer accepted")
+	}
+	if n := f.count("rdwr"); n != 1 {
+		t.Fatalf("%d I2C_RDWR calls, want no more for empty buffers", n)
+	}
+}
+
+func TestReadI2CBlockLen(t *testing.T) {
+	for _, plain := range []bool{true, false} {
+		f := newFakeBus(t)
+		d := f.add(0x48)
+		copy(d.regs[0x10:], []byte{3, 0xA1, 0xA2, 0xA3})
+		d.regs[0x40] = 40
+		if !plain {
+			f.funcMask &^= FUNC_I2C
+		}
+		smb := f.open(t, 0x48)
+
+		got, err := smb.Read_i2c_block_len(0x10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, []byte{0xA1, 0xA2, 0xA3}) {
+			t.Fatalf("plain i2c %v: got % X", plain, got)
+		}
+		rdwr, block := f.count("rdwr"), f.count("read_i2c_block_data")
+		if plain && (rdwr != 1 || block != 0) {
+			t.Fatalf("%d I2C_RDWR calls and %d block reads, want one I2C_RDWR", rdwr, block)
+		}
+		if !plain && (rdwr != 0 || block != 1) {
+			t.Fatalf("%d I2C_RDWR calls and %d block reads without plain i2c support, want one block read", rdwr, block)
+		}
+
+		// 40 bytes only fit in an I2C_RDWR read
+		got, err = smb.Read_i2c_block_len(0x40)
+		if plain && (err != nil || len(got) != 40) {
+			t.Fatalf("40 byte block: got %d bytes, %v", len(got), err)
+		}
+		if !plain && err == nil {
+			t.Fatal("40 byte block accepted without plain i2c support")
+		}
+
+		if _, err := smb.Read_i2c_block_len(0x80); err == nil {
+			t.Fatalf("plain i2c %v: empty block accepted", plain)
+		}
+	}
+}
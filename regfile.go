@@ -0,0 +1,107 @@
+package smbus
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+)
+
+// Presents a single register as an fs.File. Every byte read from the file is
+// a fresh Read_byte_data of the register, which suits FIFO or stream data
+// registers. Stat returns synthetic metadata. Closing the file does not
+// close the bus.
+func (smb *SMBus) RegisterFile(cmd byte) fs.File {
+	return &registerFile{smb: smb, cmd: cmd}
+}
+
+type registerFile struct {
+	smb    *SMBus
+	cmd    byte
+	closed bool
+}
+
+func (f *registerFile) Stat() (fs.FileInfo, error) {
+	if f.closed {
+		return nil, fs.ErrClosed
+	}
+	return registerInfo{name: fmt.Sprintf("0x%02x-0x%02x", f.smb.addr, f.cmd)}, nil
+}
+
+func (f *registerFile) Read(p []byte) (int, error) {
+	if f.closed {
+		return 0, fs.ErrClosed
+	}
+	for i := range p {
+		val, err := f.smb.Read_byte_data(f.cmd)
+		if err != nil {
+			return i, err
+		}
+		p[i] = val
+	}
+	return len(p), nil
+}
+
+func (f *registerFile) Close() error {
+	if f.closed {
+		return fs.ErrClosed
+	}
+	f.closed = true
+	return nil
+}
+
+// Presents a single register as an io.ReadWriter built on i2c block
+// transfers, so data can be streamed to and from FIFO registers with
+// io.Copy. Each Read is a single block read of up to 32 bytes and may
+// return fewer bytes than asked for; a read that returns no data gives
+// io.EOF. Write splits p into block writes of up to 32 bytes, all to cmd.
+func (smb *SMBus) RegisterReadWriter(cmd byte) io.ReadWriter {
+	return &registerRW{smb: smb, cmd: cmd}
+}
+
+type registerRW struct {
+	smb *SMBus
+	cmd byte
+}
+
+func (rw *registerRW) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(p) > 32 {
+		p = p[:32]
+	}
+	n, err := rw.smb.Read_i2c_block_data(rw.cmd, p)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (rw *registerRW) Write(p []byte) (int, error) {
+	for off := 0; off < len(p); off += 32 {
+		end := off + 32
+		if end > len(p) {
+			end = len(p)
+		}
+		if _, err := rw.smb.Write_i2c_block_data(rw.cmd, p[off:end]); err != nil {
+			return off, err
+		}
+	}
+	return len(p), nil
+}
+
+// Synthetic file metadata for a register, named after address and register
+type registerInfo struct {
+	name string
+}
+
+func (i registerInfo) Name() string       { return i.name }
+func (i registerInfo) Size() int64        { return 0 }
+func (i registerInfo) Mode() fs.FileMode  { return fs.ModeDevice | fs.ModeCharDevice | 0444 }
+func (i registerInfo) ModTime() time.Time { return time.Time{} }
+func (i registerInfo) IsDir() bool        { return false }
+func (i registerInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,107 @@
+package smbus
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+)
+
+func TestRegisterFile(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	next := byte(0)
+	// a FIFO register returning 1, 2, 3, ...
+	d.onRead = func(reg byte) (byte, error) {
+		next++
+		return next, nil
+	}
+	smb := f.open(t, 0x48)
+
+	file := smb.RegisterFile(0x40)
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(file, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, []byte{1, 2, 3, 4}) {
+		t.Fatalf("read % X, want one register read per byte", buf)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "0x48-0x40" || info.IsDir() {
+		t.Fatalf("stat gave %q", info.Name())
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Read(buf); !errors.Is(err, fs.ErrClosed) {
+		t.Fatalf("read after Close gave %v, want fs.ErrClosed", err)
+	}
+	if _, err := smb.Read_byte_data(0x00); err != nil {
+		t.Fatalf("closing the file closed the bus: %v", err)
+	}
+}
+
+func TestRegisterReadWriter(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	// a FIFO: writes append, reads return 1, 2, 3, ...
+	var fifo []byte
+	d.onWrite = func(reg, value byte) error {
+		fifo = append(fifo, value)
+		return nil
+	}
+	next := byte(0)
+	d.onRead = func(reg byte) (byte, error) {
+		next++
+		return next, nil
+	}
+	smb := f.open(t, 0x48)
+	var cmds []byte
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "write_i2c_block_data" {
+			cmds = append(cmds, cmd)
+		}
+		return nil
+	}
+	rw := smb.RegisterReadWriter(0x40)
+
+	data := make([]byte, 70)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if n, err := rw.Write(data); err != nil || n != 70 {
+		t.Fatalf("wrote %d, %v", n, err)
+	}
+	// three block writes, all to the FIFO register
+	if want := []byte{0x40, 0x40, 0x40}; !bytes.Equal(cmds, want) {
+		t.Fatalf("block writes to % X, want % X", cmds, want)
+	}
+	if !bytes.Equal(fifo, data) {
+		t.Fatalf("FIFO holds % X, want % X", fifo, data)
+	}
+
+	// a read is a single block read of at most 32 bytes
+	buf := make([]byte, 50)
+	n, err := rw.Read(buf)
+	if err != nil || n != 32 {
+		t.Fatalf("read %d, %v, want 32 bytes", n, err)
+	}
+	if buf[0] != 1 || buf[31] != 32 {
+		t.Fatalf("read % X", buf[:n])
+	}
+
+	// a failed write reports the bytes written before it
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "write_i2c_block_data" && len(fifo) >= 102 {
+			return errors.New("FIFO full")
+		}
+		return nil
+	}
+	if n, err := rw.Write(data); err == nil || n != 32 {
+		t.Fatalf("wrote %d, %v, want 32 bytes and an error", n, err)
+	}
+}
@@ -0,0 +1,432 @@
+package smbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// A register and the byte value to write to it
+type RegVal struct {
+	Cmd, Value byte
+}
+
+// Pin and unpin the calling goroutine for ReadByteDataPinned; replaced in
+// tests
+var (
+	lockOSThread   = runtime.LockOSThread
+	unlockOSThread = runtime.UnlockOSThread
+)
+
+// Returned by ReadMapped when the register holds a value missing from the table
+var ErrUnmapped = errors.New("Register value has no mapping")
+
+// Returned by ReadByteDataNonFF when every read gave the suspicious value
+var ErrBusFault = errors.New("Register reads gave only the bus fault value")
+
+// Reads a word register and decodes it using the given byte order. The
+// SMBus word protocol transfers the low byte first, so binary.LittleEndian
+// returns the value exactly as Read_word_data does. The caller must hold
+// the bus lock.
+func (smb *SMBus) readWordOrder(cmd byte, order binary.ByteOrder) (uint16, error) {
+	w, err := smb.readWordData(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return order.Uint16([]byte{byte(w), byte(w >> 8)}), nil
+}
+
+// Encodes value using the given byte order and writes it to a word register.
+// This is the inverse of readWordOrder. The caller must hold the bus lock.
+func (smb *SMBus) writeWordOrder(cmd byte, value uint16, order binary.ByteOrder) error {
+	b := make([]byte, 2)
+	order.PutUint16(b, value)
+	return smb.writeWordData(cmd, uint16(b[0])|uint16(b[1])<<8)
+}
+
+// Reads a word register holding a tick count and returns it as a duration.
+// tick is the period of a single count as given in the device datasheet.
+func (smb *SMBus) ReadDuration(cmd byte, tick time.Duration, order binary.ByteOrder) (time.Duration, error) {
+	defer smb.rlock()()
+	w, err := smb.readWordOrder(cmd, order)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(w) * tick, nil
+}
+
+// Writes a duration to a word register as a number of ticks. The value is
+// truncated to a whole number of ticks and clamped to the 0 - 0xFFFF range
+// the register can hold.
+func (smb *SMBus) WriteDuration(cmd byte, d, tick time.Duration, order binary.ByteOrder) error {
+	if tick <= 0 {
+		return errors.New("Tick period must be positive")
+	}
+	defer smb.lock()()
+	ticks := d / tick
+	if ticks < 0 {
+		ticks = 0
+	} else if ticks > 0xFFFF {
+		ticks = 0xFFFF
+	}
+	return smb.writeWordOrder(cmd, uint16(ticks), order)
+}
+
+// Reads channels consecutive word registers starting at startCmd and
+// returns each value multiplied by resolution. This suits multi-channel
+// ADCs whose results sit in adjacent registers.
+func (smb *SMBus) ReadChannels(startCmd byte, channels int, resolution float64, order binary.ByteOrder) ([]float64, error) {
+	if err := checkRange(startCmd, channels); err != nil {
+		return nil, err
+	}
+	defer smb.rlock()()
+	values := make([]float64, channels)
+	for i := range values {
+		w, err := smb.readWordOrder(startCmd+byte(i), order)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = float64(w) * resolution
+	}
+	return values, nil
+}
+
+// Reads a register whose raw value selects an entry in table, such as a
+// gain setting, and returns the mapped value. Returns ErrUnmapped if the
+// raw value is not in the table.
+func (smb *SMBus) ReadMapped(cmd byte, table map[byte]float64) (float64, error) {
+	raw, err := smb.Read_byte_data(cmd)
+	if err != nil {
+		return 0, err
+	}
+	val, ok := table[raw]
+	if !ok {
+		return 0, ErrUnmapped
+	}
+	return val, nil
+}
+
+// Reads two word registers back to back under the bus lock, keeping the
+// gap between them as small as possible. Use this for paired channels such
+// as X/Y axes that should be sampled together.
+func (smb *SMBus) ReadPair(cmdA, cmdB byte, order binary.ByteOrder) (a, b uint16, err error) {
+	defer smb.rlock()()
+	if a, err = smb.readWordOrder(cmdA, order); err != nil {
+		return 0, 0, err
+	}
+	if b, err = smb.readWordOrder(cmdB, order); err != nil {
+		return 0, 0, err
+	}
+	return a, b, nil
+}
+
+// Writes a byte to a register with its bit order reversed, for devices that
+// expect the least significant bit first.
+func (smb *SMBus) WriteByteDataReversed(cmd, value byte) error {
+	return smb.Write_byte_data(cmd, bits.Reverse8(value))
+}
+
+// Reads a byte from a register and reverses its bit order. This is the
+// inverse of WriteByteDataReversed.
+func (smb *SMBus) ReadByteDataReversed(cmd byte) (byte, error) {
+	val, err := smb.Read_byte_data(cmd)
+	if err != nil {
+		return 0, err
+	}
+	return bits.Reverse8(val), nil
+}
+
+// Writes every register in regs, carrying on after failures. The returned
+// slice holds the error for each entry of regs (nil on success). The second
+// return value is non-nil if any write failed and wraps the first failure.
+func (smb *SMBus) WriteRegistersBestEffort(regs []RegVal) ([]error, error) {
+	errs := make([]error, len(regs))
+	var first error
+	failed := 0
+	for i, r := range regs {
+		if errs[i] = smb.Write_byte_data(r.Cmd, r.Value); errs[i] != nil {
+			if first == nil {
+				first = errs[i]
+			}
+			failed++
+		}
+	}
+	if failed > 0 {
+		return errs, fmt.Errorf("%d of %d register writes failed, first: %w", failed, len(regs), first)
+	}
+	return errs, nil
+}
+
+// Applies a double-buffered configuration: writes every shadow register in
+// writes, in order, then writes loadVal to loadCmd to make the device take
+// them over at once. All writes happen under the bus lock. The load
+// command is not sent if a shadow write fails.
+func (smb *SMBus) LoadConfig(writes []RegVal, loadCmd byte, loadVal byte) error {
+	defer smb.lock()()
+	for _, w := range writes {
+		if err := smb.writeByteData(w.Cmd, w.Value); err != nil {
+			return err
+		}
+	}
+	return smb.writeByteData(loadCmd, loadVal)
+}
+
+// Writes every register in regs under the bus lock, after saving their
+// current values. If a write fails, the registers written so far are
+// restored to their saved values, in reverse order, before the error is
+// returned. The error also reports a failed restore.
+func (smb *SMBus) ApplyWithRollback(regs []RegVal) error {
+	defer smb.lock()()
+	saved := make(map[byte]byte, len(regs))
+	for _, r := range regs {
+		if _, ok := saved[r.Cmd]; ok {
+			continue
+		}
+		val, err := smb.readByteData(r.Cmd)
+		if err != nil {
+			return err
+		}
+		saved[r.Cmd] = val
+	}
+	for i, r := range regs {
+		err := smb.writeByteData(r.Cmd, r.Value)
+		if err == nil {
+			continue
+		}
+		// the failed write may have taken effect, so restore it too
+		for j := i; j >= 0; j-- {
+			cmd := regs[j].Cmd
+			if rerr := smb.writeByteData(cmd, saved[cmd]); rerr != nil {
+				return fmt.Errorf("%w; restoring register 0x%02X failed: %v", err, cmd, rerr)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// Reads a framed response from a data register one byte at a time, passing
+// each byte to parse until it reports the frame complete or returns an
+// error. The bytes are read under the bus lock so the frame is not
+// interleaved with other sequences on the bus.
+func (smb *SMBus) ReadFramed(cmd byte, parse func(b byte) (done bool, err error)) error {
+	defer smb.lock()()
+	for {
+		b, err := smb.readByteData(cmd)
+		if err != nil {
+			return err
+		}
+		done, err := parse(b)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// Reads a word register holding a two's complement value that is bits wide,
+// such as a 12-bit ADC result, and sign-extends it to an int32. Bits above
+// the value's width are ignored. bits must be between 1 and 16.
+func (smb *SMBus) ReadSignExtended(cmd byte, bits uint, order binary.ByteOrder) (int32, error) {
+	if bits < 1 || bits > 16 {
+		return 0, errors.New("Value width must be between 1 and 16 bits")
+	}
+	defer smb.rlock()()
+	w, err := smb.readWordOrder(cmd, order)
+	if err != nil {
+		return 0, err
+	}
+	v := int32(w) & (1<<bits - 1)
+	if v&(1<<(bits-1)) != 0 {
+		v -= 1 << bits
+	}
+	return v, nil
+}
+
+// Reads a byte register, returning def instead of an error if the device
+// does not acknowledge. Other errors are returned as usual. This suits
+// optional telemetry where an absent value has a known default.
+func (smb *SMBus) ReadByteDataOr(cmd, def byte) (byte, error) {
+	val, err := smb.Read_byte_data(cmd)
+	if isNAK(err) {
+		return def, nil
+	}
+	return val, err
+}
+
+// Reads the first of cmds the device acknowledges and returns that command
+// with its value, for registers that moved between device revisions.
+// Commands that are not acknowledged are skipped; other errors are
+// returned at once. If none is acknowledged, the last error is returned.
+func (smb *SMBus) ReadFirstAvailable(cmds ...byte) (cmd byte, value byte, err error) {
+	if len(cmds) == 0 {
+		return 0, 0, errors.New("No commands given")
+	}
+	defer smb.rlock()()
+	for _, cmd = range cmds {
+		value, err = smb.readByteData(cmd)
+		if !isNAK(err) {
+			return cmd, value, err
+		}
+	}
+	return 0, 0, err
+}
+
+// Reads a byte register, retrying up to attempts times with delay between
+// reads while it returns 0xFF, which is what a disconnected device usually
+// gives instead of an error. Returns the first other value, or ErrBusFault
+// if every read gave 0xFF.
+func (smb *SMBus) ReadByteDataNonFF(cmd byte, attempts int, delay time.Duration) (byte, error) {
+	return smb.ReadByteDataNot(cmd, 0xFF, attempts, delay)
+}
+
+// Same as ReadByteDataNonFF, but retries on suspect instead of 0xFF, for
+// buses whose fault value is different, such as 0x00 with pull-downs.
+func (smb *SMBus) ReadByteDataNot(cmd, suspect byte, attempts int, delay time.Duration) (byte, error) {
+	if attempts < 1 {
+		return 0, errors.New("Attempt count must be at least 1")
+	}
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		val, err := smb.Read_byte_data(cmd)
+		if err != nil {
+			return 0, err
+		}
+		if val != suspect {
+			return val, nil
+		}
+	}
+	return 0, ErrBusFault
+}
+
+// Reads a byte register and stores the value in dst, so that other
+// goroutines can load the latest value without taking any lock. dst is left
+// unchanged if the read fails.
+func (smb *SMBus) ReadByteDataAtomic(cmd byte, dst *atomic.Uint32) error {
+	val, err := smb.Read_byte_data(cmd)
+	if err != nil {
+		return err
+	}
+	dst.Store(uint32(val))
+	return nil
+}
+
+// Same as Read_byte_data, but keeps the calling goroutine on its OS thread
+// for the duration of the transfer, to reduce scheduling jitter for a
+// single timing critical read. Locks held by the caller are kept, as the
+// runtime counts nested LockOSThread calls.
+func (smb *SMBus) ReadByteDataPinned(cmd byte) (byte, error) {
+	lockOSThread()
+	defer unlockOSThread()
+	return smb.Read_byte_data(cmd)
+}
+
+// Read-modify-write of a multi-byte register spread over count consecutive
+// byte registers starting at start. The current bytes are passed to modify
+// and the bytes it returns, which must be count long, are written back.
+// The whole sequence runs under the bus lock.
+func (smb *SMBus) UpdateRange(start byte, count int, modify func(cur []byte) []byte) error {
+	if err := checkRange(start, count); err != nil {
+		return err
+	}
+	defer smb.lock()()
+	cur := make([]byte, count)
+	for i := range cur {
+		val, err := smb.readByteData(start + byte(i))
+		if err != nil {
+			return err
+		}
+		cur[i] = val
+	}
+	next := modify(cur)
+	if len(next) != count {
+		return fmt.Errorf("Modified range holds %d bytes, expected %d", len(next), count)
+	}
+	for i, val := range next {
+		if err := smb.writeByteData(start+byte(i), val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads a register of write-1-to-clear flags under the bus lock and reports
+// whether bit was set. If it was, only that bit is written back as 1 to
+// clear it, leaving other latched flags pending.
+func (smb *SMBus) TestAndClearBit(cmd byte, bit uint) (wasSet bool, err error) {
+	return smb.testAndClearBit(cmd, bit, true)
+}
+
+// Same as TestAndClearBit, for registers whose flags are cleared by writing
+// 0. The register is written back with only bit cleared.
+func (smb *SMBus) TestAndClearBitW0C(cmd byte, bit uint) (wasSet bool, err error) {
+	return smb.testAndClearBit(cmd, bit, false)
+}
+
+func (smb *SMBus) testAndClearBit(cmd byte, bit uint, w1c bool) (bool, error) {
+	if bit > 7 {
+		return false, errors.New("Bit index must be between 0 and 7")
+	}
+	mask := byte(1) << bit
+	defer smb.lock()()
+	val, err := smb.readByteData(cmd)
+	if err != nil || val&mask == 0 {
+		return false, err
+	}
+	out := val &^ mask
+	if w1c {
+		out = mask
+	}
+	return true, smb.writeByteData(cmd, out)
+}
+
+// Sets bit (0 to 7) of a register with a read-modify-write. The sequence
+// runs under the bus lock, so it does not race with other methods of this
+// package, though it is not atomic against other bus masters.
+func (smb *SMBus) Set_bit(cmd byte, bit uint) error {
+	return smb.modifyBit(cmd, bit, func(val, mask byte) byte { return val | mask })
+}
+
+// Clears bit (0 to 7) of a register, as Set_bit sets it
+func (smb *SMBus) Clear_bit(cmd byte, bit uint) error {
+	return smb.modifyBit(cmd, bit, func(val, mask byte) byte { return val &^ mask })
+}
+
+// Inverts bit (0 to 7) of a register, as Set_bit sets it
+func (smb *SMBus) Toggle_bit(cmd byte, bit uint) error {
+	return smb.modifyBit(cmd, bit, func(val, mask byte) byte { return val ^ mask })
+}
+
+// Reports whether bit (0 to 7) of a register is set
+func (smb *SMBus) Read_bit(cmd byte, bit uint) (bool, error) {
+	if bit > 7 {
+		return false, errors.New("Bit index must be between 0 and 7")
+	}
+	val, err := smb.Read_byte_data(cmd)
+	if err != nil {
+		return false, err
+	}
+	return val&(1<<bit) != 0, nil
+}
+
+// Replaces a register's value with modify(value, 1<<bit) under the bus lock.
+// The register is not written if the value does not change.
+func (smb *SMBus) modifyBit(cmd byte, bit uint, modify func(val, mask byte) byte) error {
+	if bit > 7 {
+		return errors.New("Bit index must be between 0 and 7")
+	}
+	defer smb.lock()()
+	val, err := smb.readByteData(cmd)
+	if err != nil {
+		return err
+	}
+	if next := modify(val, 1<<bit); next != val {
+		return smb.writeByteData(cmd, next)
+	}
+	return nil
+}
@@ -0,0 +1,598 @@
+package smbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadWriteDuration(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.regs[0x08], d.regs[0x09] = 0x01, 0xF4
+	smb := f.open(t, 0x48)
+
+	got, err := smb.ReadDuration(0x08, time.Millisecond, binary.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 500*time.Millisecond {
+		t.Fatalf("read %v, want 500ms", got)
+	}
+	if err := smb.WriteDuration(0x08, 2500*time.Microsecond, time.Millisecond, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if hi, lo := f.reg(0x48, 0x08), f.reg(0x48, 0x09); hi != 0x00 || lo != 0x02 {
+		t.Fatalf("wrote 0x%02X%02X, want 2 whole ticks", hi, lo)
+	}
+	if err := smb.WriteDuration(0x08, time.Hour, time.Millisecond, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if hi, lo := f.reg(0x48, 0x08), f.reg(0x48, 0x09); hi != 0xFF || lo != 0xFF {
+		t.Fatalf("wrote 0x%02X%02X, want the count clamped to 0xFFFF", hi, lo)
+	}
+	if err := smb.WriteDuration(0x08, -time.Second, time.Millisecond, binary.BigEndian); err != nil {
+		t.Fatal(err)
+	}
+	if hi, lo := f.reg(0x48, 0x08), f.reg(0x48, 0x09); hi != 0 || lo != 0 {
+		t.Fatalf("wrote 0x%02X%02X, want a negative duration clamped to 0", hi, lo)
+	}
+}
+
+func TestReadChannels(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	// channels are read from 0x10 and 0x11, each a big endian word
+	d.regs[0x10], d.regs[0x11], d.regs[0x12] = 0x01, 0x00, 0x64
+	smb := f.open(t, 0x48)
+
+	got, err := smb.ReadChannels(0x10, 2, 0.5, binary.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != 128 || got[1] != 50 {
+		t.Fatalf("got %v, want [128 50]", got)
+	}
+	if _, err := smb.ReadChannels(0xFF, 2, 1, binary.BigEndian); err == nil {
+		t.Fatal("a range past register 0xFF was accepted")
+	}
+	if _, err := smb.ReadChannels(0x10, 0, 1, binary.BigEndian); err == nil {
+		t.Fatal("zero channels were accepted")
+	}
+}
+
+func TestReadMapped(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	smb := f.open(t, 0x48)
+	gain := map[byte]float64{0: 1, 1: 2, 2: 4}
+
+	d.regs[0x01] = 2
+	if v, err := smb.ReadMapped(0x01, gain); err != nil || v != 4 {
+		t.Fatalf("got %v, %v, want 4", v, err)
+	}
+	f.setReg(0x48, 0x01, 7)
+	if _, err := smb.ReadMapped(0x01, gain); !errors.Is(err, ErrUnmapped) {
+		t.Fatalf("got %v, want ErrUnmapped", err)
+	}
+}
+
+func TestReadPair(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.regs[0x02], d.regs[0x03] = 0x12, 0x34
+	d.regs[0x04], d.regs[0x05] = 0x56, 0x78
+	smb := f.open(t, 0x48)
+
+	a, b, err := smb.ReadPair(0x02, 0x04, binary.BigEndian)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a != 0x1234 || b != 0x5678 {
+		t.Fatalf("got 0x%04X 0x%04X, want 0x1234 0x5678", a, b)
+	}
+	ops := f.ops()
+	last := ops[len(ops)-2:]
+	if last[0] != "read_word_data 0x48 0x02" || last[1] != "read_word_data 0x48 0x04" {
+		t.Fatalf("last transfers %q, want both word reads back to back", last)
+	}
+}
+
+func TestByteDataReversed(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	if err := smb.WriteByteDataReversed(0x10, 0b11000000); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.reg(0x48, 0x10); got != 0b00000011 {
+		t.Fatalf("register holds 0b%08b, want 0b00000011", got)
+	}
+	v, err := smb.ReadByteDataReversed(0x10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0b11000000 {
+		t.Fatalf("read back 0b%08b, want 0b11000000", v)
+	}
+}
+
+func TestWriteRegistersBestEffort(t *testing.T) {
+	f := newFakeBus(t)
+	errNak := errors.New("nak")
+	d := f.add(0x48)
+	d.onWrite = func(reg, value byte) error {
+		if reg == 0x02 {
+			return errNak
+		}
+		d.regs[reg] = value
+		return nil
+	}
+	smb := f.open(t, 0x48)
+
+	errs, err := smb.WriteRegistersBestEffort([]RegVal{{0x01, 0x11}, {0x02, 0x22}, {0x03, 0x33}})
+	if !errors.Is(err, errNak) {
+		t.Fatalf("got %v, want the failure of the middle write", err)
+	}
+	if len(errs) != 3 || errs[0] != nil || !errors.Is(errs[1], errNak) || errs[2] != nil {
+		t.Fatalf("per register errors %v", errs)
+	}
+	if f.reg(0x48, 0x01) != 0x11 || f.reg(0x48, 0x03) != 0x33 {
+		t.Fatal("the writes around the failed one did not happen")
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.add(0x49)
+	smb := f.open(t, 0x48)
+	other := f.open(t, 0x49)
+
+	// start a transfer on another handle during the first shadow write; it
+	// must wait until the whole sequence is done
+	done := make(chan error, 1)
+	var once sync.Once
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "write_byte_data" && cmd == 0x01 {
+			once.Do(func() {
+				go func() {
+					_, err := other.Read_byte_data(0x00)
+					done <- err
+				}()
+			})
+		}
+		return nil
+	}
+	err := smb.LoadConfig([]RegVal{{0x01, 0x11}, {0x02, 0x22}}, 0x10, 0x01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	var transfers []string
+	for _, op := range f.ops() {
+		if strings.HasPrefix(op, "write_byte_data") || strings.HasPrefix(op, "read_byte_data") {
+			transfers = append(transfers, op)
+		}
+	}
+	want := []string{
+		"write_byte_data 0x48 0x01",
+		"write_byte_data 0x48 0x02",
+		"write_byte_data 0x48 0x10",
+		"read_byte_data 0x49 0x00",
+	}
+	if fmt.Sprint(transfers) != fmt.Sprint(want) {
+		t.Fatalf("transfers %q, want the shadow writes and then the load, uninterrupted", transfers)
+	}
+}
+
+func TestReadFramed(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	// a length byte followed by that many payload bytes, then idle bytes
+	stream := []byte{0x03, 'a', 'b', 'c', 0xFF, 0xFF}
+	d.onRead = func(reg byte) (byte, error) {
+		b := stream[0]
+		stream = stream[1:]
+		return b, nil
+	}
+	smb := f.open(t, 0x48)
+
+	var frame []byte
+	want := -1
+	err := smb.ReadFramed(0x30, func(b byte) (bool, error) {
+		if want < 0 {
+			want = int(b)
+			return want == 0, nil
+		}
+		frame = append(frame, b)
+		return len(frame) == want, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(frame) != "abc" || len(stream) != 2 {
+		t.Fatalf("frame %q with %d bytes left unread, want \"abc\" and 2", frame, len(stream))
+	}
+
+	errBad := errors.New("bad frame")
+	if err := smb.ReadFramed(0x30, func(b byte) (bool, error) { return false, errBad }); err != errBad {
+		t.Fatalf("got %v, want the parser's error", err)
+	}
+}
+
+func TestReadSignExtended(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	// 12-bit values in big endian words, with junk in the top four bits
+	d.regs[0x00], d.regs[0x01] = 0xA7, 0xFF // +2047
+	d.regs[0x02], d.regs[0x03] = 0x58, 0x00 // -2048
+	d.regs[0x04], d.regs[0x05] = 0x0F, 0xFE // -2
+	smb := f.open(t, 0x48)
+
+	for _, tc := range []struct {
+		cmd  byte
+		want int32
+	}{{0x00, 2047}, {0x02, -2048}, {0x04, -2}} {
+		got, err := smb.ReadSignExtended(tc.cmd, 12, binary.BigEndian)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Fatalf("register 0x%02X read as %d, want %d", tc.cmd, got, tc.want)
+		}
+	}
+}
+
+func TestReadByteDataOr(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.regs[0x10] = 0x42
+	smb := f.open(t, 0x48)
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want byte
+		ok   bool
+	}{
+		{"present", nil, 0x42, true},
+		{"ENXIO", syscall.ENXIO, 0x99, true},
+		{"EREMOTEIO", syscall.EREMOTEIO, 0x99, true},
+		{"other error", syscall.EIO, 0, false},
+	} {
+		f.mu.Lock()
+		f.fail = func(op string, addr uint16, cmd byte) error {
+			if op == "read_byte_data" {
+				return tc.err
+			}
+			return nil
+		}
+		f.mu.Unlock()
+		v, err := smb.ReadByteDataOr(0x10, 0x99)
+		if tc.ok && (err != nil || v != tc.want) {
+			t.Fatalf("%s: got 0x%02X, %v, want 0x%02X", tc.name, v, err, tc.want)
+		}
+		if !tc.ok && !errors.Is(err, tc.err) {
+			t.Fatalf("%s: got %v, want the error returned", tc.name, err)
+		}
+	}
+}
+
+func TestReadByteDataPinned(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	var events []string
+	d.onRead = func(reg byte) (byte, error) {
+		events = append(events, "read")
+		return 0x5A, nil
+	}
+	smb := f.open(t, 0x48)
+	defer func(lock, unlock func()) { lockOSThread, unlockOSThread = lock, unlock }(lockOSThread, unlockOSThread)
+	lockOSThread = func() { events = append(events, "lock") }
+	unlockOSThread = func() { events = append(events, "unlock") }
+
+	v, err := smb.ReadByteDataPinned(0x10)
+	if err != nil || v != 0x5A {
+		t.Fatalf("read 0x%02X, %v", v, err)
+	}
+	if fmt.Sprint(events) != "[lock read unlock]" {
+		t.Fatalf("got %v, want the read between lock and unlock", events)
+	}
+}
+
+func TestUpdateRange(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	copy(d.regs[0x30:], []byte{0x11, 0x22, 0x33, 0x44})
+	smb := f.open(t, 0x48)
+
+	err := smb.UpdateRange(0x30, 4, func(cur []byte) []byte {
+		if !bytes.Equal(cur, []byte{0x11, 0x22, 0x33, 0x44}) {
+			t.Errorf("modify got % X", cur)
+		}
+		out := append([]byte(nil), cur...)
+		out[2] = 0xCC
+		return out
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []byte{0x11, 0x22, 0xCC, 0x44} {
+		if got := f.reg(0x48, 0x30+byte(i)); got != want {
+			t.Fatalf("register 0x%02X holds 0x%02X, want 0x%02X", 0x30+i, got, want)
+		}
+	}
+	if err := smb.UpdateRange(0x30, 4, func(cur []byte) []byte { return cur[:3] }); err == nil {
+		t.Fatal("a result of the wrong length was accepted")
+	}
+}
+
+func TestReadByteDataNonFF(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	reads := 0
+	d.onRead = func(reg byte) (byte, error) {
+		reads++
+		if reads == 1 {
+			return 0xFF, nil
+		}
+		return d.regs[reg], nil
+	}
+	d.regs[0x10] = 0x42
+	smb := f.open(t, 0x48)
+
+	v, err := smb.ReadByteDataNonFF(0x10, 3, time.Millisecond)
+	if err != nil || v != 0x42 || reads != 2 {
+		t.Fatalf("got 0x%02X, %v after %d reads, want 0x42 after 2", v, err, reads)
+	}
+
+	reads = 0
+	d.regs[0x10] = 0xFF
+	if _, err := smb.ReadByteDataNonFF(0x10, 3, time.Millisecond); !errors.Is(err, ErrBusFault) {
+		t.Fatalf("got %v, want ErrBusFault", err)
+	}
+	if reads != 3 {
+		t.Fatalf("%d reads, want 3", reads)
+	}
+}
+
+func TestReadByteDataNot(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	reads := 0
+	d.onRead = func(reg byte) (byte, error) {
+		reads++
+		if reads < 3 {
+			return 0x00, nil
+		}
+		return d.regs[reg], nil
+	}
+	d.regs[0x10] = 0xFF
+	smb := f.open(t, 0x48)
+
+	// 0xFF is a valid value when the suspect is 0x00
+	v, err := smb.ReadByteDataNot(0x10, 0x00, 3, time.Millisecond)
+	if err != nil || v != 0xFF || reads != 3 {
+		t.Fatalf("got 0x%02X, %v after %d reads, want 0xFF after 3", v, err, reads)
+	}
+
+	reads = 0
+	d.regs[0x10] = 0x00
+	if _, err := smb.ReadByteDataNot(0x10, 0x00, 4, time.Millisecond); !errors.Is(err, ErrBusFault) {
+		t.Fatalf("got %v, want ErrBusFault", err)
+	}
+	if reads != 4 {
+		t.Fatalf("%d reads, want 4", reads)
+	}
+	if _, err := smb.ReadByteDataNot(0x10, 0x00, 0, 0); err == nil {
+		t.Fatal("zero attempts accepted")
+	}
+}
+
+func TestReadByteDataAtomic(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	next := byte(0)
+	d.onRead = func(reg byte) (byte, error) {
+		next++
+		return next, nil
+	}
+	smb := f.open(t, 0x48)
+
+	var latest atomic.Uint32
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			prev := uint32(0)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				v := latest.Load()
+				if v < prev {
+					t.Errorf("loaded %d after %d", v, prev)
+					return
+				}
+				prev = v
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		if err := smb.ReadByteDataAtomic(0x10, &latest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	wg.Wait()
+	if v := latest.Load(); v != 100 {
+		t.Fatalf("stored %d, want the last value read, 100", v)
+	}
+
+	f.mu.Lock()
+	f.fail = func(op string, addr uint16, cmd byte) error { return syscall.EIO }
+	f.mu.Unlock()
+	if err := smb.ReadByteDataAtomic(0x10, &latest); err == nil || latest.Load() != 100 {
+		t.Fatalf("failed read gave %v and stored %d, want the old value kept", err, latest.Load())
+	}
+}
+
+func TestTestAndClearBit(t *testing.T) {
+	for _, w1c := range []bool{true, false} {
+		f := newFakeBus(t)
+		d := f.add(0x48)
+		// a register of latched flags, cleared by writing 1 or 0 to them
+		d.onWrite = func(reg, value byte) error {
+			if w1c {
+				d.regs[reg] &^= value
+			} else {
+				d.regs[reg] &= value
+			}
+			return nil
+		}
+		d.regs[0x0A] = 0b0000_0101
+		smb := f.open(t, 0x48)
+		clear := smb.TestAndClearBit
+		if !w1c {
+			clear = smb.TestAndClearBitW0C
+		}
+
+		was, err := clear(0x0A, 2)
+		if err != nil || !was {
+			t.Fatalf("w1c %v: got %v, %v for a set bit", w1c, was, err)
+		}
+		if got := f.reg(0x48, 0x0A); got != 0b0000_0001 {
+			t.Fatalf("w1c %v: flags 0b%08b after clearing bit 2, want 0b00000001", w1c, got)
+		}
+		writes := f.count("write_byte_data")
+		was, err = clear(0x0A, 2)
+		if err != nil || was {
+			t.Fatalf("w1c %v: got %v, %v for a clear bit", w1c, was, err)
+		}
+		if f.count("write_byte_data") != writes {
+			t.Fatalf("w1c %v: a clear bit was written", w1c)
+		}
+	}
+}
+
+func TestApplyWithRollback(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	copy(d.regs[0x01:], []byte{0xA1, 0xA2, 0xA3, 0xA4})
+	errNak := errors.New("nak")
+	// the new value of register 0x03 is rejected
+	d.onWrite = func(reg, value byte) error {
+		if reg == 0x03 && value == 0x33 {
+			return errNak
+		}
+		d.regs[reg] = value
+		return nil
+	}
+	smb := f.open(t, 0x48)
+
+	err := smb.ApplyWithRollback([]RegVal{{0x01, 0x11}, {0x02, 0x22}, {0x03, 0x33}, {0x04, 0x44}})
+	if !errors.Is(err, errNak) {
+		t.Fatalf("got %v, want the failed write", err)
+	}
+	for i, want := range []byte{0xA1, 0xA2, 0xA3, 0xA4} {
+		if got := f.reg(0x48, 0x01+byte(i)); got != want {
+			t.Fatalf("register 0x%02X holds 0x%02X after the rollback, want 0x%02X", 0x01+i, got, want)
+		}
+	}
+	if n := f.count("write_byte_data 0x48 0x04"); n != 0 {
+		t.Fatal("the write after the failed one ran")
+	}
+}
+
+func TestReadFirstAvailable(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.regs[0x0E] = 0x51
+	// register 0xFE is gone in this revision of the device
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "read_byte_data" && cmd == 0xFE {
+			return syscall.EREMOTEIO
+		}
+		return nil
+	}
+	smb := f.open(t, 0x48)
+
+	cmd, v, err := smb.ReadFirstAvailable(0xFE, 0x0E)
+	if err != nil || cmd != 0x0E || v != 0x51 {
+		t.Fatalf("got register 0x%02X = 0x%02X, %v, want 0x0E = 0x51", cmd, v, err)
+	}
+	if _, _, err := smb.ReadFirstAvailable(0xFE); !errors.Is(err, syscall.EREMOTEIO) {
+		t.Fatalf("got %v, want the NAK when no register answers", err)
+	}
+}
+
+func TestBitHelpers(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x10] = 0x81
+	smb := f.open(t, 0x48)
+
+	for _, tc := range []struct {
+		name string
+		op   func() error
+		want byte
+	}{
+		{"set bit 3", func() error { return smb.Set_bit(0x10, 3) }, 0x89},
+		{"clear bit 7", func() error { return smb.Clear_bit(0x10, 7) }, 0x09},
+		{"toggle bit 0", func() error { return smb.Toggle_bit(0x10, 0) }, 0x08},
+		{"toggle bit 6", func() error { return smb.Toggle_bit(0x10, 6) }, 0x48},
+	} {
+		if err := tc.op(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := f.reg(0x48, 0x10); got != tc.want {
+			t.Fatalf("%s: register holds 0x%02X, want 0x%02X", tc.name, got, tc.want)
+		}
+	}
+	for bit, want := range []bool{false, false, false, true, false, false, true, false} {
+		if got, err := smb.Read_bit(0x10, uint(bit)); err != nil || got != want {
+			t.Errorf("Read_bit(%d) = %v, %v, want %v", bit, got, err, want)
+		}
+	}
+
+	// a bit that already has the value is not written again
+	writes := f.count("write_byte_data")
+	if err := smb.Set_bit(0x10, 3); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("write_byte_data"); n != writes {
+		t.Fatalf("%d writes for an unchanged register", n-writes)
+	}
+
+	// bit indexes above 7 are rejected without touching the bus
+	start := len(f.ops())
+	if err := smb.Set_bit(0x10, 8); err == nil {
+		t.Error("Set_bit accepted bit 8")
+	}
+	if err := smb.Clear_bit(0x10, 8); err == nil {
+		t.Error("Clear_bit accepted bit 8")
+	}
+	if err := smb.Toggle_bit(0x10, 8); err == nil {
+		t.Error("Toggle_bit accepted bit 8")
+	}
+	if _, err := smb.Read_bit(0x10, 8); err == nil {
+		t.Error("Read_bit accepted bit 8")
+	}
+	if ops := f.ops()[start:]; len(ops) != 0 {
+		t.Fatalf("invalid bit indexes reached the bus: %q", ops)
+	}
+}
@@ -0,0 +1,82 @@
+package smbus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Decodes a packed BCD byte
+func fromBCD(b byte) int {
+	return int(b>>4)*10 + int(b&0x0F)
+}
+
+// Encodes a value between 0 and 99 as packed BCD
+func toBCD(v int) byte {
+	return byte(v/10)<<4 | byte(v%10)
+}
+
+// Reads the seven DS1307 style BCD time registers starting at startCmd
+// (seconds, minutes, hours, weekday, day, month, year) in one block read and
+// returns the time they hold in loc, or in UTC if loc is nil. The clock
+// halt bit in the seconds register is ignored, both 12 and 24 hour mode are
+// understood, and a set century bit in the month register (DS3231) moves
+// the year into the 2100s. Years are otherwise taken to be in the 2000s.
+func (smb *SMBus) ReadDateTime(startCmd byte, loc *time.Location) (time.Time, error) {
+	regs := make([]byte, 7)
+	n, err := smb.Read_i2c_block_data(startCmd, regs)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if n != len(regs) {
+		return time.Time{}, fmt.Errorf("Short block read: got %d of %d bytes", n, len(regs))
+	}
+	sec := fromBCD(regs[0] & 0x7F)
+	min := fromBCD(regs[1] & 0x7F)
+	var hour int
+	if regs[2]&0x40 != 0 {
+		// 12 hour mode, bit 5 flags PM
+		hour = fromBCD(regs[2]&0x1F) % 12
+		if regs[2]&0x20 != 0 {
+			hour += 12
+		}
+	} else {
+		hour = fromBCD(regs[2] & 0x3F)
+	}
+	day := fromBCD(regs[4] & 0x3F)
+	month := fromBCD(regs[5] & 0x1F)
+	year := 2000 + fromBCD(regs[6])
+	if regs[5]&0x80 != 0 {
+		year += 100
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	return time.Date(year, time.Month(month), day, hour, min, sec, 0, loc), nil
+}
+
+// Writes t to the seven DS1307 style BCD time registers starting at
+// startCmd in one block write, in 24 hour mode and with the clock running.
+// t is written in its own location; convert it first if the RTC keeps a
+// different zone. Years from 2100 on set the DS3231 century bit. The
+// weekday is stored as 1 (Sunday) to 7 (Saturday).
+func (smb *SMBus) WriteDateTime(startCmd byte, t time.Time) error {
+	if t.Year() < 2000 || t.Year() > 2199 {
+		return errors.New("Year must be between 2000 and 2199")
+	}
+	month := toBCD(int(t.Month()))
+	if t.Year() >= 2100 {
+		month |= 0x80
+	}
+	regs := []byte{
+		toBCD(t.Second()),
+		toBCD(t.Minute()),
+		toBCD(t.Hour()),
+		byte(t.Weekday()) + 1,
+		toBCD(t.Day()),
+		month,
+		toBCD(t.Year() % 100),
+	}
+	_, err := smb.Write_i2c_block_data(startCmd, regs)
+	return err
+}
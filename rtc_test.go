@@ -0,0 +1,66 @@
+package smbus
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReadDateTime(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x68)
+	smb := f.open(t, 0x68)
+
+	for _, tc := range []struct {
+		name string
+		regs []byte
+		want time.Time
+	}{
+		// clock halt bit set in the seconds register
+		{"24h", []byte{0x80 | 0x45, 0x30, 0x23, 0x03, 0x15, 0x06, 0x24},
+			time.Date(2024, 6, 15, 23, 30, 45, 0, time.UTC)},
+		{"12h AM", []byte{0x00, 0x05, 0x40 | 0x12, 0x01, 0x01, 0x01, 0x25},
+			time.Date(2025, 1, 1, 0, 5, 0, 0, time.UTC)},
+		{"12h PM", []byte{0x00, 0x05, 0x40 | 0x20 | 0x07, 0x01, 0x01, 0x01, 0x25},
+			time.Date(2025, 1, 1, 19, 5, 0, 0, time.UTC)},
+		{"century", []byte{0x00, 0x00, 0x00, 0x06, 0x01, 0x80 | 0x03, 0x01},
+			time.Date(2101, 3, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		f.mu.Lock()
+		copy(d.regs[0x00:], tc.regs)
+		f.mu.Unlock()
+		got, err := smb.ReadDateTime(0x00, time.UTC)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(tc.want) {
+			t.Fatalf("%s: read %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	got, err := smb.ReadDateTime(0x00, nil)
+	if err != nil || got.Location() != time.UTC {
+		t.Fatalf("read %v, %v with a nil location, want a UTC time", got, err)
+	}
+}
+
+func TestWriteDateTime(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x68)
+	smb := f.open(t, 0x68)
+
+	// a Tuesday
+	if err := smb.WriteDateTime(0x00, time.Date(2101, 3, 1, 17, 4, 9, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	got := append([]byte(nil), d.regs[:7]...)
+	f.mu.Unlock()
+	want := []byte{0x09, 0x04, 0x17, 0x03, 0x01, 0x80 | 0x03, 0x01}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("wrote % X, want % X", got, want)
+	}
+	if err := smb.WriteDateTime(0x00, time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC)); err == nil {
+		t.Fatal("a year before 2000 was accepted")
+	}
+}
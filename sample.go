@@ -0,0 +1,41 @@
+package smbus
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Limits how often an event is passed on. When both fields are set, an
+// event must pass both limits.
+type SampleRate struct {
+	// pass one in Every events; 0 or 1 passes every event
+	Every uint64
+	// pass at most one event per Interval; 0 disables the limit
+	Interval time.Duration
+}
+
+// Applies a SampleRate. The zero value is ready to use and safe for
+// concurrent use.
+type sampler struct {
+	count atomic.Uint64
+	last  atomic.Int64 // UnixNano of the last event passed on
+}
+
+// Reports whether the current event should be passed on under rate
+func (s *sampler) allow(rate SampleRate) bool {
+	if rate.Every > 1 && s.count.Add(1)%rate.Every != 1 {
+		return false
+	}
+	if rate.Interval > 0 {
+		now := time.Now().UnixNano()
+		last := s.last.Load()
+		if last != 0 && now-last < int64(rate.Interval) {
+			return false
+		}
+		// another goroutine may have passed an event in the meantime
+		if !s.last.CompareAndSwap(last, now) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package smbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTraceSampleRate(t *testing.T) {
+	f := newFakeBus(t)
+	// nothing answers at 0x48, so every read fails
+	smb := f.open(t, 0x48)
+	traced := 0
+	smb.Trace = func(op string, cmd byte, data []byte, err error) {
+		if err == nil {
+			t.Errorf("%s succeeded", op)
+		}
+		traced++
+	}
+	smb.TraceSampleRate = SampleRate{Every: 10}
+
+	for i := 0; i < 100; i++ {
+		if _, err := smb.Read_byte_data(0x00); err == nil {
+			t.Fatal("read from a missing device succeeded")
+		}
+	}
+	if traced != 10 {
+		t.Fatalf("Trace saw %d of 100 failures, want 10", traced)
+	}
+}
+
+func TestSamplerInterval(t *testing.T) {
+	var s sampler
+	rate := SampleRate{Interval: 30 * time.Millisecond}
+	if !s.allow(rate) {
+		t.Fatal("the first event was dropped")
+	}
+	if s.allow(rate) {
+		t.Fatal("a second event within the interval was passed on")
+	}
+	time.Sleep(rate.Interval)
+	if !s.allow(rate) {
+		t.Fatal("an event after the interval was dropped")
+	}
+}
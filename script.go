@@ -0,0 +1,40 @@
+package smbus
+
+import (
+	"fmt"
+	"time"
+)
+
+// One step of a script run by RunScript
+type ScriptStep struct {
+	Cmd, Value byte
+	// Time to wait after the step before starting the next one
+	Delay time.Duration
+	// Read Cmd and check that it holds Value instead of writing Value to it
+	Expect bool
+}
+
+// Runs steps in order: each writes Value to register Cmd, or with Expect
+// set reads Cmd and checks it holds Value, then waits for the step's Delay.
+// The whole script runs under the bus lock, delays included, so it is not
+// interleaved with other transfers on the bus. The script stops at the
+// first failing step; a failed expectation returns an error wrapping
+// ErrUnexpectedValue.
+func (smb *SMBus) RunScript(steps []ScriptStep) error {
+	defer smb.lock()()
+	for i, s := range steps {
+		if s.Expect {
+			val, err := smb.readByteData(s.Cmd)
+			if err != nil {
+				return fmt.Errorf("Script step %d: %w", i, err)
+			}
+			if val != s.Value {
+				return fmt.Errorf("Script step %d: %w: register 0x%02X is 0x%02X, expected 0x%02X", i, ErrUnexpectedValue, s.Cmd, val, s.Value)
+			}
+		} else if err := smb.writeByteData(s.Cmd, s.Value); err != nil {
+			return fmt.Errorf("Script step %d: %w", i, err)
+		}
+		time.Sleep(s.Delay)
+	}
+	return nil
+}
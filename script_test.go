@@ -0,0 +1,52 @@
+package smbus
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRunScript(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	var times []time.Time
+	d.onWrite = func(reg, value byte) error {
+		times = append(times, time.Now())
+		d.regs[reg] = value
+		return nil
+	}
+	smb := f.open(t, 0x48)
+	start := len(f.ops())
+
+	err := smb.RunScript([]ScriptStep{
+		{Cmd: 0x00, Value: 0x80, Delay: 20 * time.Millisecond},
+		{Cmd: 0x01, Value: 0x0F},
+		{Cmd: 0x01, Value: 0x0F, Expect: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"write_byte_data 0x48 0x00",
+		"write_byte_data 0x48 0x01",
+		"read_byte_data 0x48 0x01",
+	}
+	if got := f.ops()[start:]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("transfers %q, want %q", got, want)
+	}
+	if gap := times[1].Sub(times[0]); gap < 20*time.Millisecond {
+		t.Fatalf("second step ran %v after the first, want the 20ms delay", gap)
+	}
+
+	err = smb.RunScript([]ScriptStep{
+		{Cmd: 0x01, Value: 0x0E, Expect: true},
+		{Cmd: 0x02, Value: 0x01},
+	})
+	if !errors.Is(err, ErrUnexpectedValue) {
+		t.Fatalf("got %v, want ErrUnexpectedValue", err)
+	}
+	if f.reg(0x48, 0x02) != 0 {
+		t.Fatal("the step after a failed expectation ran")
+	}
+}
@@ -17,19 +17,119 @@ import "C"
 import (
 	"errors"
 	"fmt"
+	"math/bits"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"syscall"
-	"unsafe"
+	"time"
 )
 
 const (
-	i2c_SLAVE = 0x0703
+	i2c_RETRIES     = 0x0701
+	i2c_TIMEOUT     = 0x0702
+	i2c_SLAVE       = 0x0703
+	i2c_TENBIT      = 0x0704
+	i2c_FUNCS       = 0x0705
+	i2c_SLAVE_FORCE = 0x0706
+	i2c_RDWR        = 0x0707
+	i2c_PEC         = 0x0708
+
+	i2c_M_RD  = 0x0001
+	i2c_M_TEN = 0x0010
 )
 
-// Base type. Wraps a bus device and an address
+// Base type. Wraps a bus device and an address.
+// Methods are safe for concurrent use by multiple goroutines: each one holds
+// the bus lock for its whole transfer, including the address selection, or
+// for its whole sequence of transfers. Bus_open and Bus_close must not run
+// concurrently with other methods.
 type SMBus struct {
+	// Maximum fraction of time, between 0 and 1, this handle may keep the
+	// bus busy, measured over a sliding window. Transfers are delayed to
+	// stay below it, before they take the bus lock so other handles can use
+	// the bus meanwhile. Zero disables the limit.
+	MaxDutyCycle float64
+	// Lets read-only sequences, such as ReadPair or Export, share the bus
+	// lock with other readers instead of taking it exclusively. Writes
+	// still wait for all readers. This only helps readers on separate
+	// handles, as transfers on a single file descriptor are serialized by
+	// the kernel anyway.
+	RWMode bool
+	// Number of times a transaction that lost arbitration to another bus
+	// master (EAGAIN) or found the bus busy (EBUSY) is retried, after a
+	// short random backoff. Only useful on multi-master buses.
+	ArbitrationRetries int
+	// Called after every transaction with the operation name, such as
+	// "read_byte_data", the command byte, the bytes transferred and the
+	// error, if any. Data is nil for failed transactions.
+	Trace func(op string, cmd byte, data []byte, err error)
+	// Limits how often Trace is called, to keep error storms from flooding
+	// logs. The zero value calls Trace for every transaction.
+	TraceSampleRate SampleRate
+	// Number of recent transactions kept for History. Zero keeps none.
+	// Changing it discards the transactions kept so far.
+	HistorySize int
+
 	bus  *os.File
-	addr byte
+	path string
+	// carries the ioctls on bus
+	tr   transport
+	addr uint16
+	// addr is a 10-bit address
+	tenbit bool
+	// addr was selected with I2C_SLAVE_FORCE
+	force bool
+	// select addr again on the next transfer even if it did not change
+	reselect bool
+	// Packet Error Checking enabled on the file descriptor
+	pec bool
+	// held for every transfer and multi-register sequence, shared with
+	// every other handle on the same bus
+	mu *busLock
+	// register ranges cached by ReadRangeCached
+	cacheMu sync.Mutex
+	ranges  map[rangeKey]rangeEntry
+	// writes pending for WriteByteDataCoalesced, keyed by register, and
+	// the first error of a background write since FlushCoalesced. coalesceMu
+	// is held while a coalesced value is written.
+	coalesceMu  sync.Mutex
+	coalesced   map[byte]*coalescedWrite
+	coalesceErr error
+	// bus scan cached by BuildTopology
+	topoMu sync.Mutex
+	topo   *Topology
+	// recent transactions, for HistorySize
+	history history
+	// chain added with Use, and built from it; useMu is held while Use or
+	// UsePacer changes them
+	useMu      sync.Mutex
+	middleware []Middleware
+	chain      OpFunc
+	// pacers added with UsePacer, waited for before taking the lock
+	pacers atomic.Pointer[[]Pacer]
+	// the exclusive lock was taken by lock or rlock and the call has not
+	// run a transaction or switched addresses yet
+	fresh bool
+	// bus time used, for MaxDutyCycle
+	duty dutyCycle
+	// sampling state for TraceSampleRate
+	traceSampler sampler
+	// samples dropped by Stream
+	dropped atomic.Uint64
+}
+
+// Counters describing a handle's activity
+type Stats struct {
+	// samples Stream dropped because the consumer was not keeping up
+	Dropped uint64
+}
+
+// Returns the handle's activity counters
+func (smb *SMBus) Stats() Stats {
+	return Stats{Dropped: smb.dropped.Load()}
 }
 
 // Factory method for SMBus
@@ -41,182 +141,846 @@ func New(bus uint, address byte) (*SMBus, error) {
 	}
 	err = smb.Set_addr(address)
 	if err != nil {
+		smb.Bus_close()
+		return nil, err
+	}
+	return smb, nil
+}
+
+// Same as New, but opens the bus character device at path
+func NewFromPath(path string, address byte) (*SMBus, error) {
+	smb := &SMBus{bus: nil}
+	err := smb.Bus_open_path(path)
+	if err != nil {
+		return nil, err
+	}
+	err = smb.Set_addr(address)
+	if err != nil {
+		smb.Bus_close()
 		return nil, err
 	}
 	return smb, nil
 }
 
+// Same as New, but wraps a bus file opened by the caller, for example one
+// set up for a multiplexer channel. The handle takes ownership of f:
+// Bus_close closes it, and f is closed as well if the address cannot be
+// selected. Handles share a bus lock by file name, so f should be opened
+// by its device path.
+func NewFromFile(f *os.File, address byte) (*SMBus, error) {
+	smb := &SMBus{bus: nil}
+	smb.attach(f)
+	err := smb.Set_addr(address)
+	if err != nil {
+		smb.Bus_close()
+		return nil, err
+	}
+	return smb, nil
+}
+
+// Opens a bus, runs fn against it and closes the bus again, even if fn
+// panics. Returns the error returned by fn, or the error from closing the
+// bus if fn succeeded.
+func WithBus(bus uint, addr byte, fn func(*SMBus) error) (err error) {
+	smb, err := New(bus, addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := smb.Bus_close(); err == nil {
+			err = cerr
+		}
+	}()
+	return fn(smb)
+}
+
+// Directory holding the i2c-N bus device files
+var devRoot = "/dev"
+
 // Opens a new bus file with a given index. Will return an error if a bus is already open
 func (smb *SMBus) Bus_open(bus uint) error {
+	return smb.Bus_open_path(filepath.Join(devRoot, fmt.Sprintf("i2c-%d", bus)))
+}
+
+// Opens the bus character device at path, such as "/dev/i2c/1" or a udev
+// symlink. Will return an error if a bus is already open
+func (smb *SMBus) Bus_open_path(path string) error {
 
 	if smb.bus != nil {
 		return errors.New("Can only open one bus at at time")
 	}
-	path := fmt.Sprintf("/dev/i2c-%d", bus)
 	//f, err := os.OpenFile(path, os.O_RDWR, 0600)
 	f, err := os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
 		return err
 	}
-	smb.bus = f
+	smb.attach(f)
 	return nil
 }
 
-// Closes an open bus file
+// Makes f the handle's bus file, sharing the bus lock with every other
+// handle on the same device
+func (smb *SMBus) attach(f *os.File) {
+	path := f.Name()
+	// handles opened through different symlinks still share one lock
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	smb.bus = f
+	smb.path = path
+	smb.tr = defaultTransport
+	smb.mu = sharedBusLock(path)
+	// the cached address belongs to a previous file, if any, and the
+	// address selected on f is unknown
+	smb.reselect = true
+}
+
+// Closes an open bus file, after writing the values pending for
+// WriteByteDataCoalesced. Closing a handle that is not open, or a nil
+// handle, does nothing. The handle is closed even if an error is returned.
 func (smb *SMBus) Bus_close() error {
+	if smb == nil || smb.bus == nil {
+		return nil
+	}
+	ferr := smb.FlushCoalesced()
 	err := smb.bus.Close()
+	smb.bus = nil
+	smb.path = ""
+	if err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// Same as Bus_close, to implement io.Closer
+func (smb *SMBus) Close() error {
+	return smb.Bus_close()
+}
+
+// Describes the handle by its bus device and address, such as
+// "SMBus(/dev/i2c-1@0x48)"
+func (smb *SMBus) String() string {
+	if smb == nil || smb.bus == nil {
+		return "SMBus(closed)"
+	}
+	return fmt.Sprintf("SMBus(%s@0x%02X)", smb.path, smb.addr)
+}
+
+// Set the device bus address to a value between 0x00 and 0x77. This
+// switches a handle in 10-bit mode back to 7-bit addressing.
+func (smb *SMBus) Set_addr(addr byte) error {
+	smb.mu.Lock()
+	defer smb.mu.Unlock()
+	return smb.setAddr(addr)
+}
+
+// Set the device bus address to a 10-bit value between 0x000 and 0x3FF and
+// switch the handle to 10-bit addressing until the next Set_addr.
+func (smb *SMBus) Set_addr_10bit(addr uint16) error {
+	if addr > 0x3FF {
+		return fmt.Errorf("Address 0x%x does not fit in 10 bits", addr)
+	}
+	f, err := smb.funcs()
 	if err != nil {
 		return err
-	} else {
-		smb.bus = nil
-		return nil
 	}
+	if f&FUNC_10BIT_ADDR == 0 {
+		return errors.New("Adapter does not support 10-bit addresses")
+	}
+	smb.mu.Lock()
+	defer smb.mu.Unlock()
+	return smb.selectAddr(addr, true, false)
 }
 
-// Set the device bus address to a value between 0x00 and 0x77
-func (smb *SMBus) Set_addr(addr byte) error {
-	if smb.addr != addr {
-		if err := ioctl(smb.bus.Fd(), i2c_SLAVE, uintptr(addr)); err != nil {
-			return err
+// Same as Set_addr, but selects the address with I2C_SLAVE_FORCE, which
+// succeeds even if a kernel driver, such as a hwmon driver, has claimed the
+// address. The driver knows nothing about this handle's transfers, so they
+// can corrupt the device state the driver relies on, and vice versa. The
+// handle keeps forcing its address until the next Set_addr.
+func (smb *SMBus) Set_addr_force(addr byte) error {
+	smb.mu.Lock()
+	defer smb.mu.Unlock()
+	return smb.selectAddr(uint16(addr), false, true)
+}
+
+func (smb *SMBus) setAddr(addr byte) error {
+	return smb.selectAddr(uint16(addr), false, false)
+}
+
+// Selects addr with the I2C_SLAVE ioctl, or I2C_SLAVE_FORCE if force is set,
+// switching the addressing mode with I2C_TENBIT first if it changes.
+// Nothing is sent if addr and the mode are already selected. Errors are
+// returned as *OpError with Op "select_addr".
+func (smb *SMBus) selectAddr(addr uint16, tenbit, force bool) error {
+	if smb.addr != addr || smb.tenbit != tenbit || smb.force != force {
+		// a retry must not wait without the lock after a switch
+		smb.fresh = false
+	}
+	if smb.tenbit != tenbit || smb.reselect {
+		var arg uintptr
+		if tenbit {
+			arg = 1
+		}
+		if err := smb.tr.ioctl(smb.bus.Fd(), i2c_TENBIT, arg); err != nil {
+			return &OpError{Op: "select_addr", Addr: addr, Err: err}
+		}
+		smb.tenbit = tenbit
+		smb.reselect = true
+	}
+	if smb.addr != addr || smb.force != force || smb.reselect {
+		req := uintptr(i2c_SLAVE)
+		if force {
+			req = i2c_SLAVE_FORCE
+		}
+		if err := smb.tr.ioctl(smb.bus.Fd(), req, uintptr(addr)); err != nil {
+			return &OpError{Op: "select_addr", Addr: addr, Err: err}
 		}
 		smb.addr = addr
+		smb.force = force
+		smb.reselect = false
 	}
 	return nil
 }
 
-func ioctl(fd, cmd, arg uintptr) error {
-	_, _, errno := syscall.Syscall6(syscall.SYS_IOCTL, fd, cmd, arg, 0, 0, 0)
-	if errno != 0 {
-		return errno
+// Selects the handle's address again if ForceReselectNext asked for it
+func (smb *SMBus) selectCurrent() error {
+	return smb.selectAddr(smb.addr, smb.tenbit, smb.force)
+}
+
+// Returns a function that selects the handle's current address and
+// addressing mode again, for restoring them after a temporary switch.
+func (smb *SMBus) saveAddr() func() error {
+	addr, tenbit, force := smb.addr, smb.tenbit, smb.force
+	return func() error {
+		return smb.selectAddr(addr, tenbit, force)
 	}
+}
+
+// Makes the next transfer select the slave address again even if it did
+// not change, for when something else may have changed the address
+// selected on the file descriptor. Address caching resumes afterwards.
+func (smb *SMBus) ForceReselectNext() {
+	smb.mu.Lock()
+	smb.reselect = true
+	smb.mu.Unlock()
+}
+
+// Returned by Enable_PEC when the adapter cannot do Packet Error Checking
+var ErrPECUnsupported = errors.New("Adapter does not support PEC")
+
+// Turns SMBus Packet Error Checking on or off for this handle. While it is
+// on, the kernel appends a CRC-8 byte to every SMBus transfer and checks the
+// one sent by the device, failing the transfer on a mismatch. The PEC byte
+// never appears in the caller's buffers, so block reads need no extra room
+// for it. Raw I2C transfers, such as ReadBytesBatch, are not covered.
+func (smb *SMBus) Enable_PEC(on bool) error {
+	if on {
+		f, err := smb.funcs()
+		if err != nil {
+			return err
+		}
+		if f&FUNC_SMBUS_PEC == 0 {
+			return ErrPECUnsupported
+		}
+	}
+	smb.mu.Lock()
+	defer smb.mu.Unlock()
+	var arg uintptr
+	if on {
+		arg = 1
+	}
+	if err := smb.tr.ioctl(smb.bus.Fd(), i2c_PEC, arg); err != nil {
+		return fmt.Errorf("Cannot set PEC: %w", err)
+	}
+	smb.pec = on
 	return nil
 }
 
-// Sends a single bit to the device, at the place of the Rd/Wr bit.
-func (smb SMBus) Write_quick(value byte) error {
-	smb.Set_addr(smb.addr)
-	_, err := C.i2c_smbus_write_quick(C.int(smb.bus.Fd()), C.__u8(value))
+// Reports whether Packet Error Checking is enabled on this handle
+func (smb *SMBus) PEC_enabled() bool {
+	smb.mu.Lock()
+	defer smb.mu.Unlock()
+	return smb.pec
+}
+
+// Sets how long the adapter waits for a transfer to complete before giving
+// up, rounded up to the kernel's 10ms units with a minimum of one unit.
+// This is a setting of the adapter, so it affects every later transfer on
+// the bus, not only those of this handle.
+func (smb *SMBus) Set_timeout(d time.Duration) error {
+	units := (d + 10*time.Millisecond - 1) / (10 * time.Millisecond)
+	if units < 1 {
+		units = 1
+	}
+	smb.mu.Lock()
+	defer smb.mu.Unlock()
+	return smb.tr.ioctl(smb.bus.Fd(), i2c_TIMEOUT, uintptr(units))
+}
+
+// Sets how many times the adapter retries a transfer that lost
+// arbitration. Like Set_timeout, this affects every transfer on the bus.
+func (smb *SMBus) Set_retries(n int) error {
+	if n < 0 {
+		return errors.New("Retry count must not be negative")
+	}
+	smb.mu.Lock()
+	defer smb.mu.Unlock()
+	return smb.tr.ioctl(smb.bus.Fd(), i2c_RETRIES, uintptr(n))
+}
+
+// Same as Read_byte_data, but selects the slave address again first, as
+// with ForceReselectNext.
+func (smb *SMBus) ReadByteDataFresh(cmd byte) (byte, error) {
+	defer smb.lock()()
+	smb.reselect = true
+	return smb.readByteData(cmd)
+}
+
+// Runs fn with the slave address switched to addr, then switches back to
+// the handle's previous address.
+func (smb *SMBus) withAddr(addr byte, fn func() error) error {
+	restore := smb.saveAddr()
+	if err := smb.setAddr(addr); err != nil {
+		return err
+	}
+	err := fn()
+	if rerr := restore(); err == nil {
+		err = rerr
+	}
 	return err
 }
 
+// Reads a byte register of the device at addr without changing the
+// handle's address. The address is switched and restored under the handle
+// lock.
+func (smb *SMBus) ReadByteDataFrom(addr, cmd byte) (val byte, err error) {
+	defer smb.lock()()
+	err = smb.withAddr(addr, func() error {
+		val, err = smb.readByteData(cmd)
+		return err
+	})
+	return val, err
+}
+
+// Writes a byte register of the device at addr without changing the
+// handle's address. The address is switched and restored under the handle
+// lock.
+func (smb *SMBus) WriteByteDataTo(addr, cmd, value byte) error {
+	defer smb.lock()()
+	return smb.withAddr(addr, func() error {
+		return smb.writeByteData(cmd, value)
+	})
+}
+
+// Adapter functionality masks keyed by bus device path. All handles on a bus
+// share one adapter, so the mask is only queried once per bus.
+var funcsCache = struct {
+	sync.Mutex
+	masks map[string]uint64
+}{masks: make(map[string]uint64)}
+
+// Returns the adapter functionality mask, querying it with the I2C_FUNCS
+// ioctl the first time any handle on this bus asks for it.
+func (smb *SMBus) funcs() (uint64, error) {
+	funcsCache.Lock()
+	defer funcsCache.Unlock()
+	if f, ok := funcsCache.masks[smb.path]; ok {
+		return f, nil
+	}
+	f, err := smb.tr.funcs(smb.bus.Fd())
+	if err != nil {
+		return 0, err
+	}
+	funcsCache.masks[smb.path] = f
+	return f, nil
+}
+
+// Drops the cached functionality mask of this handle's bus, so the next
+// query goes to the adapter again. This affects every handle on the bus.
+func (smb *SMBus) RefreshFuncs() {
+	funcsCache.Lock()
+	delete(funcsCache.masks, smb.path)
+	funcsCache.Unlock()
+}
+
+// Runs fn with the bus file descriptor while holding the bus lock, so
+// custom ioctls are serialized with the transfers of this package on any
+// handle of the bus. fn must not call methods of the handle.
+func (smb *SMBus) DoIoctl(fn func(fd uintptr) error) error {
+	smb.mu.Lock()
+	defer smb.mu.Unlock()
+	return fn(smb.bus.Fd())
+}
+
+// Returned for a failed bus transaction or address selection. Err is the
+// underlying error, usually a syscall.Errno, so errors.Is(err, syscall.ENXIO)
+// and the like see through it.
+type OpError struct {
+	// operation name, such as "read_byte_data"
+	Op   string
+	Addr uint16
+	// command byte, 0 for operations without one
+	Cmd byte
+	Err error
+}
+
+func (e *OpError) Error() string {
+	return fmt.Sprintf("%s at 0x%02X, command 0x%02X: %v", e.Op, e.Addr, e.Cmd, e.Err)
+}
+
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// Reports whether err means the device did not acknowledge. Depending on
+// the adapter driver a missing acknowledge surfaces as ENXIO or EREMOTEIO.
+func isNAK(err error) bool {
+	return errors.Is(err, syscall.ENXIO) || errors.Is(err, syscall.EREMOTEIO)
+}
+
+// Reports whether err means another master won the bus
+func lostArbitration(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EBUSY)
+}
+
+// Returns a random backoff before retry attempt (counted from 0), drawn
+// from a range that doubles with every attempt, starting at 0 - 1ms
+func arbitrationBackoff(attempt int) time.Duration {
+	if attempt > 10 {
+		attempt = 10
+	}
+	return time.Duration(rand.Int63n(int64(time.Millisecond) << uint(attempt)))
+}
+
+// Runs a single bus transaction fn through the middleware chain. When
+// MaxDutyCycle is set, this records the bus time the transaction takes;
+// lock and rlock wait for the cap. Transactions that lost arbitration are
+// retried up to ArbitrationRetries times. Errors are returned as *OpError.
+// op and cmd describe the
+// transaction to the middlewares, the Trace hook and the history; data, if
+// not nil, returns the bytes transferred and is only called when needed.
+func (smb *SMBus) transact(op string, cmd byte, fn func() error, data func() []byte) error {
+	run := func() error {
+		err := smb.throttled(fn)
+		for attempt := 0; attempt < smb.ArbitrationRetries && lostArbitration(err); attempt++ {
+			time.Sleep(arbitrationBackoff(attempt))
+			err = smb.throttled(fn)
+		}
+		return err
+	}
+	// only the first transaction of a call may release the lock in Op.Wait
+	first := smb.fresh
+	if first {
+		smb.fresh = false
+	}
+	var err error
+	if smb.chain != nil {
+		o := Op{Name: op, Cmd: cmd, Addr: smb.addr, run: run}
+		o.wait = func(d time.Duration) bool {
+			if !first {
+				return true
+			}
+			return smb.waitUnlocked(o.Addr, d)
+		}
+		err = smb.chain(o)
+	} else {
+		err = run()
+	}
+	if smb.Trace != nil && smb.traceSampler.allow(smb.TraceSampleRate) {
+		var d []byte
+		if data != nil && err == nil {
+			d = data()
+		}
+		smb.Trace(op, cmd, d, err)
+	}
+	if smb.HistorySize > 0 {
+		rec := TransactionRecord{Time: time.Now(), Op: op, Addr: smb.addr, Cmd: cmd, Err: err}
+		if data != nil && err == nil {
+			rec.Data = append([]byte(nil), data()...)
+		}
+		smb.history.add(rec, smb.HistorySize)
+	}
+	if err != nil {
+		return &OpError{Op: op, Addr: smb.addr, Cmd: cmd, Err: err}
+	}
+	return nil
+}
+
+// Releases the bus lock for d and selects addr again once it is back. Returns
+// false if the handle was closed or switched away from addr meanwhile, or
+// the address cannot be selected.
+func (smb *SMBus) waitUnlocked(addr uint16, d time.Duration) bool {
+	tenbit, force := smb.tenbit, smb.force
+	smb.mu.Unlock()
+	time.Sleep(d)
+	smb.mu.Lock()
+	if smb.bus == nil || smb.addr != addr || smb.tenbit != tenbit || smb.force != force {
+		return false
+	}
+	return smb.selectCurrent() == nil
+}
+
+// Runs fn, recording the bus time it takes if MaxDutyCycle is set
+func (smb *SMBus) throttled(fn func() error) error {
+	if smb.MaxDutyCycle <= 0 || smb.MaxDutyCycle >= 1 {
+		return fn()
+	}
+	start := time.Now()
+	err := fn()
+	smb.duty.record(start, time.Now())
+	return err
+}
+
+// Sends a single bit to the device, at the place of the Rd/Wr bit.
+func (smb *SMBus) Write_quick(value byte) error {
+	defer smb.lock()()
+	return smb.writeQuick(value)
+}
+
+func (smb *SMBus) writeQuick(value byte) error {
+	if err := smb.selectCurrent(); err != nil {
+		return err
+	}
+	return smb.transact("write_quick", 0, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), value, 0, i2c_SMBUS_QUICK, nil)
+	}, func() []byte { return []byte{value} })
+}
+
 // Reads a single byte from a device, without specifying a device
 // register. Some devices are so simple that this interface is enough;
 // for others, it is a shorthand if you want to read the same register
 // as in the previous SMBus command.
-func (smb SMBus) Read_byte() (byte, error) {
-	smb.Set_addr(smb.addr)
-	ret, err := C.i2c_smbus_read_byte(C.int(smb.bus.Fd()))
+func (smb *SMBus) Read_byte() (byte, error) {
+	defer smb.rlock()()
+	return smb.readByte()
+}
+
+func (smb *SMBus) readByte() (byte, error) {
+	if err := smb.selectCurrent(); err != nil {
+		return 0, err
+	}
+	var data smbusData
+	err := smb.transact("read_byte", 0, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_READ, 0, i2c_SMBUS_BYTE, &data)
+	}, func() []byte { return data[:1] })
 	if err != nil {
-		ret = 0
+		return 0, err
 	}
-	return byte(ret & 0x0FF), err
+	return data[0], nil
 }
 
 // This operation is the reverse of Receive Byte: it sends a single
 // byte to a device. See Receive Byte for more information.
-func (smb SMBus) Write_byte(value byte) error {
-	smb.Set_addr(smb.addr)
-	_, err := C.i2c_smbus_write_byte(C.int(smb.bus.Fd()), C.__u8(value))
-	return err
+func (smb *SMBus) Write_byte(value byte) error {
+	defer smb.lock()()
+	return smb.writeByte(value)
+}
+
+func (smb *SMBus) writeByte(value byte) error {
+	if err := smb.selectCurrent(); err != nil {
+		return err
+	}
+	return smb.transact("write_byte", value, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_WRITE, value, i2c_SMBUS_BYTE, nil)
+	}, nil)
 }
 
 // Reads a single byte from a device, from a designated register.
 // The register is specified through the cmd byte
-func (smb SMBus) Read_byte_data(cmd byte) (byte, error) {
-	smb.Set_addr(smb.addr)
-	ret, err := C.i2c_smbus_read_byte_data(C.int(smb.bus.Fd()), C.__u8(cmd))
+func (smb *SMBus) Read_byte_data(cmd byte) (byte, error) {
+	defer smb.rlock()()
+	return smb.readByteData(cmd)
+}
+
+func (smb *SMBus) readByteData(cmd byte) (byte, error) {
+	if err := smb.selectCurrent(); err != nil {
+		return 0, err
+	}
+	var data smbusData
+	err := smb.transact("read_byte_data", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_READ, cmd, i2c_SMBUS_BYTE_DATA, &data)
+	}, func() []byte { return data[:1] })
 	if err != nil {
-		ret = 0
+		return 0, err
 	}
-	return byte(ret & 0x0FF), err
+	return data[0], nil
 }
 
 // Writes a single byte to a device, to a designated register. The
 // register is specified through the cmd byte. This is the opposite
 // of the Read Byte operation.
-func (smb SMBus) Write_byte_data(cmd, value byte) error {
-	smb.Set_addr(smb.addr)
-	_, err := C.i2c_smbus_write_byte_data(C.int(smb.bus.Fd()), C.__u8(cmd), C.__u8(value))
-	return err
+func (smb *SMBus) Write_byte_data(cmd, value byte) error {
+	defer smb.lock()()
+	return smb.writeByteData(cmd, value)
+}
+
+func (smb *SMBus) writeByteData(cmd, value byte) error {
+	if err := smb.selectCurrent(); err != nil {
+		return err
+	}
+	data := smbusData{value}
+	return smb.transact("write_byte_data", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_WRITE, cmd, i2c_SMBUS_BYTE_DATA, &data)
+	}, func() []byte { return []byte{value} })
 }
 
 // This operation is very like Read Byte; again, data is read from a
 // device, from a designated register that is specified through the cmd
-// byte. But this time, the data is a complete word (16 bits).
+// byte. But this time, the data is a complete word (16 bits). As SMBus
+// specifies, the first byte on the wire is the low byte of the word, so
+// the value is little-endian; see Read_word_data_be for MSB-first devices.
 func (smb *SMBus) Read_word_data(cmd byte) (uint16, error) {
-	smb.Set_addr(smb.addr)
-	ret, err := C.i2c_smbus_read_word_data(C.int(smb.bus.Fd()), C.__u8(cmd))
+	defer smb.rlock()()
+	return smb.readWordData(cmd)
+}
+
+func (smb *SMBus) readWordData(cmd byte) (uint16, error) {
+	if err := smb.selectCurrent(); err != nil {
+		return 0, err
+	}
+	var data smbusData
+	err := smb.transact("read_word_data", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_READ, cmd, i2c_SMBUS_WORD_DATA, &data)
+	}, func() []byte { w := data.word(); return []byte{byte(w), byte(w >> 8)} })
 	if err != nil {
-		ret = 0
+		return 0, err
 	}
-	return uint16(ret & 0x0FFFF), err
+	return data.word(), nil
 }
 
 // This is the opposite of the Read Word operation. 16 bits
 // of data is written to a device, to the designated register that is
-// specified through the cmd byte.
-func (smb SMBus) Write_word_data(cmd byte, value uint16) error {
-	smb.Set_addr(smb.addr)
-	_, err := C.i2c_smbus_write_word_data(C.int(smb.bus.Fd()), C.__u8(cmd), C.__u16(value))
-	return err
+// specified through the cmd byte. The low byte is sent first, as with
+// Read_word_data.
+func (smb *SMBus) Write_word_data(cmd byte, value uint16) error {
+	defer smb.lock()()
+	return smb.writeWordData(cmd, value)
+}
+
+func (smb *SMBus) writeWordData(cmd byte, value uint16) error {
+	if err := smb.selectCurrent(); err != nil {
+		return err
+	}
+	var data smbusData
+	data.setWord(value)
+	return smb.transact("write_word_data", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_WRITE, cmd, i2c_SMBUS_WORD_DATA, &data)
+	}, func() []byte { return []byte{byte(value), byte(value >> 8)} })
+}
+
+// Same as Read_word_data, for devices that send the high byte first
+func (smb *SMBus) Read_word_data_be(cmd byte) (uint16, error) {
+	w, err := smb.Read_word_data(cmd)
+	return bits.ReverseBytes16(w), err
+}
+
+// Same as Write_word_data, for devices that expect the high byte first
+func (smb *SMBus) Write_word_data_be(cmd byte, value uint16) error {
+	return smb.Write_word_data(cmd, bits.ReverseBytes16(value))
 }
 
 // This command selects a device register (through the cmd byte), sends
 // 16 bits of data to it, and reads 16 bits of data in return.
-func (smb SMBus) Process_call(cmd byte, value uint16) (uint16, error) {
-	smb.Set_addr(smb.addr)
-	ret, err := C.i2c_smbus_process_call(C.int(smb.bus.Fd()), C.__u8(cmd), C.__u16(value))
+func (smb *SMBus) Process_call(cmd byte, value uint16) (uint16, error) {
+	defer smb.lock()()
+	return smb.processCall(cmd, value)
+}
+
+func (smb *SMBus) processCall(cmd byte, value uint16) (uint16, error) {
+	if err := smb.selectCurrent(); err != nil {
+		return 0, err
+	}
+	var data smbusData
+	data.setWord(value)
+	err := smb.transact("process_call", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_WRITE, cmd, i2c_SMBUS_PROC_CALL, &data)
+	}, func() []byte { w := data.word(); return []byte{byte(w), byte(w >> 8)} })
 	if err != nil {
-		ret = 0
+		return 0, err
 	}
-	return uint16(ret & 0x0FFFF), err
+	return data.word(), nil
 }
 
 // This command reads a block of up to 32 bytes from a device, from a
 // designated register that is specified through the cmd byte. The amount
 // of data in byte is specified by the length of the buf slice.
 // To read 4 bytes of data, pass a slice created like this: make([]byte, 4)
-func (smb SMBus) Read_block_data(cmd byte, buf []byte) (int, error) {
-	smb.Set_addr(smb.addr)
-	ret, err := C.i2c_smbus_read_block_data(
-		C.int(smb.bus.Fd()),
-		C.__u8(cmd),
-		(*C.__u8)(unsafe.Pointer(&buf[0])),
-	)
-	return int(ret), err
+func (smb *SMBus) Read_block_data(cmd byte, buf []byte) (int, error) {
+	defer smb.rlock()()
+	return smb.readBlockData(cmd, buf)
+}
+
+func (smb *SMBus) readBlockData(cmd byte, buf []byte) (int, error) {
+	if err := checkBlockLen(buf); err != nil {
+		return 0, err
+	}
+	if err := smb.selectCurrent(); err != nil {
+		return 0, err
+	}
+	// the kernel returns as many bytes as the device sends, up to 32
+	var data smbusData
+	err := smb.transact("read_block_data", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_READ, cmd, i2c_SMBUS_BLOCK_DATA, &data)
+	}, data.block)
+	if err != nil {
+		return 0, err
+	}
+	if n := len(data.block()); n > len(buf) {
+		return 0, fmt.Errorf("Device returned %d bytes, buffer holds %d", n, len(buf))
+	}
+	return copy(buf, data.block()), nil
+}
+
+// Same as Read_block_data, but allocates the buffer itself and returns the
+// data trimmed to the length the device sent.
+func (smb *SMBus) Read_block(cmd byte) ([]byte, error) {
+	buf := make([]byte, 32)
+	n, err := smb.Read_block_data(cmd, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
 }
 
 // The opposite of the Block Read command, this writes up to 32 bytes to
 // a device, to a designated register that is specified through the
 // cmd byte. The amount of data is specified by the lengts of buf.
-func (smb SMBus) Write_block_data(cmd byte, buf []byte) (int, error) {
-	smb.Set_addr(smb.addr)
-	ret, err := C.i2c_smbus_write_block_data(C.int(smb.bus.Fd()), C.__u8(cmd), C.__u8(len(buf)), ((*C.__u8)(&buf[0])))
-	return int(ret), err
+func (smb *SMBus) Write_block_data(cmd byte, buf []byte) (int, error) {
+	defer smb.lock()()
+	return smb.writeBlockData(cmd, buf)
+}
+
+func (smb *SMBus) writeBlockData(cmd byte, buf []byte) (int, error) {
+	if err := checkBlockLen(buf); err != nil {
+		return 0, err
+	}
+	if err := smb.selectCurrent(); err != nil {
+		return 0, err
+	}
+	var data smbusData
+	data.setBlock(buf)
+	err := smb.transact("write_block_data", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_WRITE, cmd, i2c_SMBUS_BLOCK_DATA, &data)
+	}, func() []byte { return buf })
+	return 0, err
 }
 
 // Block read method for devices without SMBus support. Uses plain i2c interface
-func (smb SMBus) Read_i2c_block_data(cmd byte, buf []byte) (int, error) {
-	smb.Set_addr(smb.addr)
-	ret, err := C.i2c_smbus_read_i2c_block_data(C.int(smb.bus.Fd()), C.__u8(cmd), C.__u8(len(buf)), ((*C.__u8)(&buf[0])))
-	return int(ret), err
+func (smb *SMBus) Read_i2c_block_data(cmd byte, buf []byte) (int, error) {
+	defer smb.rlock()()
+	return smb.readI2CBlockData(cmd, buf)
+}
+
+func (smb *SMBus) readI2CBlockData(cmd byte, buf []byte) (int, error) {
+	if err := checkBlockLen(buf); err != nil {
+		return 0, err
+	}
+	if err := smb.selectCurrent(); err != nil {
+		return 0, err
+	}
+	var data smbusData
+	data[0] = byte(len(buf))
+	size := i2c_SMBUS_I2C_BLOCK_DATA
+	if len(buf) == 32 {
+		size = i2c_SMBUS_I2C_BLOCK_BROKEN
+	}
+	err := smb.transact("read_i2c_block_data", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_READ, cmd, size, &data)
+	}, data.block)
+	if err != nil {
+		return 0, err
+	}
+	return copy(buf, data.block()), nil
 }
 
 // Block write method for devices without SMBus support. Uses plain i2c interface
-func (smb SMBus) Write_i2c_block_data(cmd byte, buf []byte) (int, error) {
-	smb.Set_addr(smb.addr)
-	ret, err := C.i2c_smbus_write_i2c_block_data(C.int(smb.bus.Fd()), C.__u8(cmd), C.__u8(len(buf)), ((*C.__u8)(&buf[0])))
-	return int(ret), err
+func (smb *SMBus) Write_i2c_block_data(cmd byte, buf []byte) (int, error) {
+	defer smb.lock()()
+	return smb.writeI2CBlockData(cmd, buf)
+}
+
+func (smb *SMBus) writeI2CBlockData(cmd byte, buf []byte) (int, error) {
+	if err := checkBlockLen(buf); err != nil {
+		return 0, err
+	}
+	if err := smb.selectCurrent(); err != nil {
+		return 0, err
+	}
+	var data smbusData
+	data.setBlock(buf)
+	err := smb.transact("write_i2c_block_data", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_WRITE, cmd, i2c_SMBUS_I2C_BLOCK_BROKEN, &data)
+	}, func() []byte { return buf })
+	return 0, err
 }
 
 // This command selects a device register (through the cmd byte), sends
 // 1 to 31 bytes of data to it, and reads 1 to 31 bytes of data in return.
-func (smb SMBus) Block_process_call(cmd byte, buf []byte) ([]byte, error) {
-	smb.Set_addr(smb.addr)
-	ret, err := C.i2c_smbus_block_process_call(C.int(smb.bus.Fd()), C.__u8(cmd), C.__u8(len(buf)), ((*C.__u8)(&buf[0])))
+func (smb *SMBus) Block_process_call(cmd byte, buf []byte) ([]byte, error) {
+	defer smb.lock()()
+	return smb.blockProcessCall(cmd, buf)
+}
+
+func (smb *SMBus) blockProcessCall(cmd byte, buf []byte) ([]byte, error) {
+	n, err := smb.blockProcessCallInto(cmd, buf, buf)
 	if err != nil {
 		return nil, err
-	} else {
-		return buf[:ret], nil
 	}
+	return buf[:n], nil
+}
+
+// Same as Block_process_call, but with separate buffers for the data sent
+// and the data received, so send is never overwritten. send must hold 1 to
+// 32 bytes. The reply is copied into recv and the number of bytes received
+// is returned; an error is returned if recv is too small to hold it.
+func (smb *SMBus) BlockProcessCallInto(cmd byte, send []byte, recv []byte) (int, error) {
+	defer smb.lock()()
+	return smb.blockProcessCallInto(cmd, send, recv)
+}
+
+func (smb *SMBus) blockProcessCallInto(cmd byte, send []byte, recv []byte) (int, error) {
+	if err := checkBlockLen(send); err != nil {
+		return 0, err
+	}
+	if len(recv) == 0 {
+		return 0, errors.New("Receive buffer must not be empty")
+	}
+	if err := smb.selectCurrent(); err != nil {
+		return 0, err
+	}
+	var data smbusData
+	data.setBlock(send)
+	err := smb.transact("block_process_call", cmd, func() error {
+		return smb.tr.smbus(smb.bus.Fd(), i2c_SMBUS_WRITE, cmd, i2c_SMBUS_BLOCK_PROC_CALL, &data)
+	}, data.block)
+	if err != nil {
+		return 0, err
+	}
+	if n := len(data.block()); n > len(recv) {
+		return 0, fmt.Errorf("Device returned %d bytes, receive buffer holds %d", n, len(recv))
+	}
+	return copy(recv, data.block()), nil
+}
+
+// Checks that count registers from start, with count at least 1, fit in
+// the 256 register space of a device
+func checkRange(start byte, count int) error {
+	if count < 1 {
+		return fmt.Errorf("Register count %d must be at least 1", count)
+	}
+	if int(start)+count > 0x100 {
+		return fmt.Errorf("%d registers from 0x%02X exceed the register space", count, start)
+	}
+	return nil
+}
+
+// Checks that buf holds 1 to 32 bytes, the block size limit of SMBus
+func checkBlockLen(buf []byte) error {
+	if len(buf) == 0 {
+		return errors.New("Block buffer must not be empty")
+	}
+	if len(buf) > 32 {
+		return fmt.Errorf("Block buffer holds %d bytes, the limit is 32", len(buf))
+	}
+	return nil
 }
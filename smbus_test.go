@@ -0,0 +1,817 @@
+package smbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestByteDataRoundTrip(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	if err := smb.Write_byte_data(0x10, 0x5A); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.reg(0x48, 0x10); got != 0x5A {
+		t.Fatalf("register holds 0x%02X, want 0x5A", got)
+	}
+	v, err := smb.Read_byte_data(0x10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0x5A {
+		t.Fatalf("read 0x%02X, want 0x5A", v)
+	}
+	if err := smb.Write_word_data(0x20, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if lo, hi := f.reg(0x48, 0x20), f.reg(0x48, 0x21); lo != 0x34 || hi != 0x12 {
+		t.Fatalf("word stored as 0x%02X 0x%02X, want low byte first", lo, hi)
+	}
+	w, err := smb.Read_word_data(0x20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 0x1234 {
+		t.Fatalf("read word 0x%04X, want 0x1234", w)
+	}
+}
+
+func TestWithBusClosesBus(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	errFn := errors.New("fn failed")
+	for _, want := range []error{nil, errFn} {
+		err := WithBus(1, 0x48, func(smb *SMBus) error {
+			if n := openFiles(t, f.path); n != 1 {
+				t.Errorf("%d bus files open inside fn, want 1", n)
+			}
+			return want
+		})
+		if err != want {
+			t.Fatalf("WithBus returned %v, want %v", err, want)
+		}
+		if n := openFiles(t, f.path); n != 0 {
+			t.Fatalf("%d bus files left open after fn returned %v", n, want)
+		}
+	}
+}
+
+func TestBlockProcessCallInto(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).call = func(cmd byte, in []byte) ([]byte, error) {
+		out := make([]byte, len(in)+1)
+		for i, v := range in {
+			out[i] = ^v
+		}
+		return out, nil
+	}
+	smb := f.open(t, 0x48)
+
+	send := []byte{0x01, 0x02, 0x03}
+	recv := make([]byte, 8)
+	n, err := smb.BlockProcessCallInto(0x30, send, recv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recv[:n], []byte{0xFE, 0xFD, 0xFC, 0x00}) {
+		t.Fatalf("received % X", recv[:n])
+	}
+	if !bytes.Equal(send, []byte{0x01, 0x02, 0x03}) {
+		t.Fatalf("send was overwritten with % X", send)
+	}
+	if _, err := smb.BlockProcessCallInto(0x30, send, make([]byte, 3)); err == nil {
+		t.Fatal("a 4 byte reply into a 3 byte buffer was accepted")
+	}
+}
+
+func TestFuncsQueriedOncePerBus(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.add(0x49)
+	a := f.open(t, 0x48)
+	b := f.open(t, 0x49)
+
+	for _, smb := range []*SMBus{a, b, a} {
+		if _, err := smb.Funcs(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := f.count("funcs"); n != 1 {
+		t.Fatalf("I2C_FUNCS issued %d times for one bus, want once", n)
+	}
+}
+
+func TestDoIoctlHoldsLock(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	done := make(chan error, 1)
+	err := smb.DoIoctl(func(fd uintptr) error {
+		if fd != smb.bus.Fd() {
+			t.Errorf("fn got fd %d, want %d", fd, smb.bus.Fd())
+		}
+		go func() {
+			_, _, err := smb.ReadPair(0x00, 0x02, binary.LittleEndian)
+			done <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+		if f.count("read_word_data") != 0 {
+			t.Error("a locked sequence ran while fn held the lock")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestByteDataOtherAddress(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x00] = 0xAA
+	f.add(0x49).regs[0x00] = 0xBB
+	smb := f.open(t, 0x48)
+
+	if v, err := smb.ReadByteDataFrom(0x49, 0x00); err != nil || v != 0xBB {
+		t.Fatalf("read 0x%02X, %v from 0x49", v, err)
+	}
+	if err := smb.WriteByteDataTo(0x49, 0x01, 0x5A); err != nil {
+		t.Fatal(err)
+	}
+	if f.reg(0x49, 0x01) != 0x5A || f.reg(0x48, 0x01) != 0 {
+		t.Fatal("WriteByteDataTo wrote to the wrong device")
+	}
+	if smb.addr != 0x48 {
+		t.Fatalf("handle address is 0x%02X afterwards, want 0x48", smb.addr)
+	}
+	if v, err := smb.Read_byte_data(0x00); err != nil || v != 0xAA {
+		t.Fatalf("read 0x%02X, %v from the handle's own device", v, err)
+	}
+}
+
+func TestArbitrationRetries(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x10] = 0x5A
+	smb := f.open(t, 0x48)
+	lost := 0
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "read_byte_data" && lost > 0 {
+			lost--
+			return syscall.EAGAIN
+		}
+		return nil
+	}
+
+	lost = 2
+	smb.ArbitrationRetries = 3
+	if v, err := smb.Read_byte_data(0x10); err != nil || v != 0x5A {
+		t.Fatalf("read 0x%02X, %v after losing arbitration twice", v, err)
+	}
+	if n := f.count("read_byte_data"); n != 3 {
+		t.Fatalf("%d attempts, want 3", n)
+	}
+
+	lost = 1
+	smb.ArbitrationRetries = 0
+	if _, err := smb.Read_byte_data(0x10); !errors.Is(err, syscall.EAGAIN) {
+		t.Fatalf("got %v, want EAGAIN without retries", err)
+	}
+}
+
+func TestReadByteDataFresh(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x10] = 0x5A
+	smb := f.open(t, 0x48)
+	if _, err := smb.Read_byte_data(0x10); err != nil {
+		t.Fatal(err)
+	}
+
+	slaves := f.count("slave")
+	if v, err := smb.ReadByteDataFresh(0x10); err != nil || v != 0x5A {
+		t.Fatalf("read 0x%02X, %v", v, err)
+	}
+	if n := f.count("slave") - slaves; n != 1 {
+		t.Fatalf("I2C_SLAVE issued %d times for an unchanged address, want once", n)
+	}
+	ops := f.ops()
+	if last := ops[len(ops)-2:]; last[0] != "slave 0x48" || last[1] != "read_byte_data 0x48 0x10" {
+		t.Fatalf("last operations %q, want the selection right before the read", last)
+	}
+	// caching resumes afterwards
+	if _, err := smb.Read_byte_data(0x10); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("slave") - slaves; n != 1 {
+		t.Fatalf("I2C_SLAVE issued again for the next plain read")
+	}
+}
+
+// Hammers one handle from many goroutines, mixing transfers to its own
+// address with transfers to another device that switch the address and
+// back. Run with -race.
+func TestConcurrentByteData(t *testing.T) {
+	f := newFakeBus(t)
+	a := f.add(0x48)
+	b := f.add(0x49)
+	for i := range a.regs {
+		a.regs[i] = 0xAA
+		b.regs[i] = 0xBB
+	}
+	smb := f.open(t, 0x48)
+
+	const workers, rounds = 8, 200
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			reg := byte(0x80 + w)
+			for i := 0; i < rounds; i++ {
+				if err := smb.Write_byte_data(reg, byte(i)); err != nil {
+					errs <- err
+					return
+				}
+				v, err := smb.Read_byte_data(reg)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if v != byte(i) {
+					t.Errorf("worker %d read 0x%02X, want 0x%02X", w, v, byte(i))
+					return
+				}
+				if v, err := smb.Read_byte_data(0x00); err != nil || v != 0xAA {
+					t.Errorf("own device read 0x%02X, %v", v, err)
+					return
+				}
+				if v, err := smb.ReadByteDataFrom(0x49, 0x00); err != nil || v != 0xBB {
+					t.Errorf("other device read 0x%02X, %v", v, err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestEnablePEC(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	if err := smb.Enable_PEC(true); err != nil {
+		t.Fatal(err)
+	}
+	if !smb.PEC_enabled() {
+		t.Fatal("PEC_enabled is false after Enable_PEC(true)")
+	}
+	if err := smb.Enable_PEC(false); err != nil {
+		t.Fatal(err)
+	}
+	if smb.PEC_enabled() {
+		t.Fatal("PEC_enabled is true after Enable_PEC(false)")
+	}
+	var got []string
+	for _, op := range f.ops() {
+		if strings.HasPrefix(op, "pec ") {
+			got = append(got, op)
+		}
+	}
+	if want := []string{"pec 1", "pec 0"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ops %q, want %q", got, want)
+	}
+
+	// a failed ioctl leaves the state alone
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "pec" {
+			return syscall.EINVAL
+		}
+		return nil
+	}
+	if err := smb.Enable_PEC(true); !errors.Is(err, syscall.EINVAL) {
+		t.Fatalf("got %v, want EINVAL", err)
+	}
+	if smb.PEC_enabled() {
+		t.Fatal("PEC_enabled is true after a failed Enable_PEC")
+	}
+}
+
+func TestEnablePECUnsupported(t *testing.T) {
+	f := newFakeBus(t)
+	f.funcMask &^= FUNC_SMBUS_PEC
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	if err := smb.Enable_PEC(true); err != ErrPECUnsupported {
+		t.Fatalf("got %v, want ErrPECUnsupported", err)
+	}
+	if n := f.count("pec"); n != 0 {
+		t.Fatalf("%d I2C_PEC ioctls on an adapter without PEC", n)
+	}
+	// turning it off does not need adapter support
+	if err := smb.Enable_PEC(false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetAddr10bit(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.add(0x2A5)
+	smb := f.open(t, 0x48)
+	start := len(f.ops())
+
+	if err := smb.Set_addr_10bit(0x2A5); err != nil {
+		t.Fatal(err)
+	}
+	if err := smb.Set_addr_10bit(0x2A5); err != nil {
+		t.Fatal(err)
+	}
+	if err := smb.Set_addr(0x48); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, op := range f.ops()[start:] {
+		if op != "funcs" {
+			got = append(got, op)
+		}
+	}
+	// the repeated selection sends nothing
+	want := []string{"tenbit 1", "slave 0x2A5", "tenbit 0", "slave 0x48"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("ioctls %q, want %q", got, want)
+	}
+	if err := smb.Set_addr_10bit(0x400); err == nil {
+		t.Fatal("address 0x400 was accepted")
+	}
+
+	f.funcMask &^= FUNC_10BIT_ADDR
+	smb.RefreshFuncs()
+	if err := smb.Set_addr_10bit(0x2A5); err == nil {
+		t.Fatal("10-bit address accepted by an adapter without FUNC_10BIT_ADDR")
+	}
+}
+
+// A failed address selection is returned as it is, before any transfer
+// is attempted
+func TestSelectErrorBeforeTransfer(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+	f.fail = func(op string, addr uint16, cmd byte) error {
+		if op == "slave" {
+			return syscall.EIO
+		}
+		return nil
+	}
+	smb.ForceReselectNext()
+
+	_, err := smb.Read_byte_data(0x10)
+	var oerr *OpError
+	if !errors.As(err, &oerr) || oerr.Op != "select_addr" || oerr.Addr != 0x48 {
+		t.Fatalf("got %v, want an *OpError for the address selection", err)
+	}
+	if oerr.Unwrap() != syscall.EIO {
+		t.Fatalf("%v does not unwrap to EIO", err)
+	}
+	if n := f.count("read_byte_data"); n != 0 {
+		t.Fatalf("%d transfers after a failed address selection, want 0", n)
+	}
+
+	// an address claimed by a driver
+	f.fail = nil
+	f.busy[0x4C] = true
+	err = smb.Set_addr(0x4C)
+	if !errors.As(err, &oerr) || oerr.Op != "select_addr" || oerr.Addr != 0x4C || oerr.Unwrap() != syscall.EBUSY {
+		t.Fatalf("got %v, want an *OpError wrapping EBUSY", err)
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+	for _, tc := range []struct {
+		start byte
+		count int
+		ok    bool
+	}{{0x00, 0, false}, {0x00, 0x100, true}, {0xFF, 1, true}, {0xFF, 2, false}, {0x10, -1, false}} {
+		if err := checkRange(tc.start, tc.count); (err == nil) != tc.ok {
+			t.Errorf("%d from 0x%02X: got %v, want ok %v", tc.count, tc.start, err, tc.ok)
+		}
+	}
+}
+
+func TestCheckBlockLen(t *testing.T) {
+	for _, tc := range []struct {
+		n  int
+		ok bool
+	}{{0, false}, {1, true}, {32, true}, {33, false}} {
+		if err := checkBlockLen(make([]byte, tc.n)); (err == nil) != tc.ok {
+			t.Errorf("%d bytes: got %v, want ok %v", tc.n, err, tc.ok)
+		}
+	}
+}
+
+// Oversized blocks are rejected by the public methods before anything is
+// sent to the device
+func TestOversizedBlockRejected(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+	start := len(f.ops())
+
+	buf := make([]byte, 40)
+	if _, err := smb.Write_block_data(0x10, buf); err == nil {
+		t.Error("Write_block_data accepted 40 bytes")
+	}
+	if _, err := smb.Write_i2c_block_data(0x10, buf); err == nil {
+		t.Error("Write_i2c_block_data accepted 40 bytes")
+	}
+	if _, err := smb.Block_process_call(0x10, buf); err == nil {
+		t.Error("Block_process_call accepted 40 bytes")
+	}
+	if ops := f.ops()[start:]; len(ops) != 0 {
+		t.Fatalf("oversized blocks reached the bus: %q", ops)
+	}
+}
+
+func TestSetAddrForce(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	f.add(0x4C).regs[0x00] = 0x19
+	f.busy[0x4C] = true
+	smb := f.open(t, 0x48)
+
+	if err := smb.Set_addr(0x4C); !errors.Is(err, syscall.EBUSY) {
+		t.Fatalf("Set_addr on a claimed address: got %v, want EBUSY", err)
+	}
+	start := len(f.ops())
+	if err := smb.Set_addr_force(0x4C); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := smb.Read_byte_data(0x00); err != nil || v != 0x19 {
+		t.Fatalf("read 0x%02X, %v", v, err)
+	}
+	want := []string{"slave_force 0x4C", "read_byte_data 0x4C 0x00"}
+	if got := f.ops()[start:]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ops %q, want %q", got, want)
+	}
+
+	// a plain Set_addr drops the force again
+	if err := smb.Set_addr(0x4C); !errors.Is(err, syscall.EBUSY) {
+		t.Fatalf("Set_addr after Set_addr_force: got %v, want EBUSY", err)
+	}
+}
+
+func TestReadBlock(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.blocks[0x30] = []byte{0xDE, 0xAD, 0xBE}
+	smb := f.open(t, 0x48)
+
+	got, err := smb.Read_block(0x30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte{0xDE, 0xAD, 0xBE}) {
+		t.Fatalf("got % X, want the 3 bytes the device sent", got)
+	}
+
+	f.fail = func(op string, addr uint16, cmd byte) error { return syscall.EIO }
+	if got, err := smb.Read_block(0x30); !errors.Is(err, syscall.EIO) || got != nil {
+		t.Fatalf("got % X, %v, want nil and EIO", got, err)
+	}
+}
+
+func TestNewFromPathSymlink(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x10] = 0x5A
+	link := filepath.Join(t.TempDir(), "sensor-bus")
+	if err := os.Symlink(f.path, link); err != nil {
+		t.Fatal(err)
+	}
+	smb, err := NewFromPath(link, 0x48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smb.Bus_close()
+
+	if v, err := smb.Read_byte_data(0x10); err != nil || v != 0x5A {
+		t.Fatalf("read 0x%02X, %v", v, err)
+	}
+	// the symlink and the device name share one bus lock
+	if other := f.open(t, 0x48); other.mu != smb.mu {
+		t.Fatal("handle opened through a symlink has its own bus lock")
+	}
+}
+
+func TestNewFromFile(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48).regs[0x10] = 0x5A
+	file, err := os.OpenFile(f.path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smb, err := NewFromFile(file, 0x48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := smb.Read_byte_data(0x10); err != nil || v != 0x5A {
+		t.Fatalf("read 0x%02X, %v", v, err)
+	}
+	if err := smb.Bus_close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := openFiles(t, f.path); n != 0 {
+		t.Fatalf("Bus_close left %d bus files open", n)
+	}
+
+	f.busy[0x49] = true
+	file, err = os.OpenFile(f.path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFromFile(file, 0x49); err == nil {
+		t.Fatal("selecting an address claimed by a driver succeeded")
+	}
+	if n := openFiles(t, f.path); n != 0 {
+		t.Fatalf("%d bus files left open after a failed NewFromFile", n)
+	}
+}
+
+func TestSetTimeout(t *testing.T) {
+	f := newFakeBus(t)
+	smb := f.open(t, 0x48)
+	for _, tc := range []struct {
+		d    time.Duration
+		want string
+	}{
+		{100 * time.Millisecond, "timeout 10"},
+		// rounded up to the next 10ms unit
+		{101 * time.Millisecond, "timeout 11"},
+		{time.Millisecond, "timeout 1"},
+		// at least one unit
+		{0, "timeout 1"},
+		{-time.Second, "timeout 1"},
+	} {
+		if err := smb.Set_timeout(tc.d); err != nil {
+			t.Fatal(err)
+		}
+		ops := f.ops()
+		if got := ops[len(ops)-1]; got != tc.want {
+			t.Errorf("Set_timeout(%v) sent %q, want %q", tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestSetRetries(t *testing.T) {
+	f := newFakeBus(t)
+	smb := f.open(t, 0x48)
+	for _, tc := range []struct {
+		n    int
+		want string
+	}{
+		{0, "retries 0"},
+		{3, "retries 3"},
+		// negative counts are rejected without an ioctl
+		{-1, ""},
+	} {
+		before := f.count("retries")
+		err := smb.Set_retries(tc.n)
+		if tc.want == "" {
+			if err == nil || f.count("retries") != before {
+				t.Errorf("Set_retries(%d) = %v, want an error and no ioctl", tc.n, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ops := f.ops()
+		if got := ops[len(ops)-1]; got != tc.want {
+			t.Errorf("Set_retries(%d) sent %q, want %q", tc.n, got, tc.want)
+		}
+	}
+}
+
+func TestOpError(t *testing.T) {
+	f := newFakeBus(t)
+	// nothing answers at 0x49
+	smb := f.open(t, 0x49)
+
+	_, err := smb.Read_byte_data(0x10)
+	var oerr *OpError
+	if !errors.As(err, &oerr) {
+		t.Fatalf("got %T %v, want *OpError", err, err)
+	}
+	if oerr.Op != "read_byte_data" || oerr.Addr != 0x49 || oerr.Cmd != 0x10 {
+		t.Fatalf("got %+v", oerr)
+	}
+	if oerr.Unwrap() != syscall.ENXIO || !errors.Is(err, syscall.ENXIO) {
+		t.Fatalf("%v does not unwrap to ENXIO", err)
+	}
+	if want := "read_byte_data at 0x49, command 0x10: " + syscall.ENXIO.Error(); err.Error() != want {
+		t.Fatalf("message %q, want %q", err.Error(), want)
+	}
+}
+
+func TestWordByteOrder(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	d.regs[0x10], d.regs[0x11] = 0x12, 0x34
+	smb := f.open(t, 0x48)
+
+	// the SMBus word is sent low byte first
+	if w, err := smb.Read_word_data(0x10); err != nil || w != 0x3412 {
+		t.Fatalf("Read_word_data = 0x%04X, %v, want 0x3412", w, err)
+	}
+	if w, err := smb.Read_word_data_be(0x10); err != nil || w != 0x1234 {
+		t.Fatalf("Read_word_data_be = 0x%04X, %v, want 0x1234", w, err)
+	}
+
+	if err := smb.Write_word_data_be(0x20, 0xABCD); err != nil {
+		t.Fatal(err)
+	}
+	if hi, lo := f.reg(0x48, 0x20), f.reg(0x48, 0x21); hi != 0xAB || lo != 0xCD {
+		t.Fatalf("Write_word_data_be sent 0x%02X 0x%02X, want 0xAB 0xCD", hi, lo)
+	}
+	if err := smb.Write_word_data(0x20, 0xABCD); err != nil {
+		t.Fatal(err)
+	}
+	if lo, hi := f.reg(0x48, 0x20), f.reg(0x48, 0x21); lo != 0xCD || hi != 0xAB {
+		t.Fatalf("Write_word_data sent 0x%02X 0x%02X, want 0xCD 0xAB", lo, hi)
+	}
+}
+
+func TestAddressSelectedOnce(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+
+	if _, err := smb.Read_byte_data(0x00); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := smb.Read_byte_data(0x01); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("slave"); n != 1 {
+		t.Fatalf("I2C_SLAVE issued %d times, want once", n)
+	}
+}
+
+// Reopening the bus on the same handle selects the address again, as the
+// new file descriptor starts without one
+func TestAddressSelectedAfterReopen(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb := f.open(t, 0x48)
+	if _, err := smb.Read_byte_data(0x00); err != nil {
+		t.Fatal(err)
+	}
+	if err := smb.Bus_close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := smb.Bus_open(1); err != nil {
+		t.Fatal(err)
+	}
+
+	slaves := f.count("slave")
+	if _, err := smb.Read_byte_data(0x00); err != nil {
+		t.Fatal(err)
+	}
+	if n := f.count("slave") - slaves; n != 1 {
+		t.Fatalf("I2C_SLAVE issued %d times after reopening, want once", n)
+	}
+}
+
+func TestTraceData(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	copy(d.regs[0x20:], []byte{0x11, 0x22, 0x33})
+	d.blocks[0x30] = []byte{0xA1, 0xA2}
+	smb := f.open(t, 0x48)
+	type call struct {
+		op   string
+		cmd  byte
+		data []byte
+	}
+	var calls []call
+	smb.Trace = func(op string, cmd byte, data []byte, err error) {
+		calls = append(calls, call{op, cmd, append([]byte(nil), data...)})
+	}
+
+	r := make([]byte, 3)
+	if _, err := smb.WriteRead([]byte{0x20}, r); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := smb.ReadBytesBatch([]byte{0x22, 0x20}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := smb.Read_block_data(0x30, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := smb.Write_i2c_block_data(0x40, []byte{0x01, 0x02, 0x03}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := smb.Read_i2c_block_data(0x21, make([]byte, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []call{
+		{"rdwr", 0, []byte{0x11, 0x22, 0x33}},
+		{"rdwr", 0, []byte{0x33, 0x11}},
+		{"read_block_data", 0x30, []byte{0xA1, 0xA2}},
+		{"write_i2c_block_data", 0x40, []byte{0x01, 0x02, 0x03}},
+		{"read_i2c_block_data", 0x21, []byte{0x22, 0x33}},
+	}
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("traced %+v, want %+v", calls, want)
+	}
+}
+
+func TestBusCloseTwice(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb, err := NewFromPath(f.path, 0x48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := smb.Bus_close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := smb.Bus_close(); err != nil {
+		t.Fatalf("second Bus_close: %v", err)
+	}
+
+	var zero SMBus
+	if err := zero.Bus_close(); err != nil {
+		t.Fatalf("closing a zero SMBus: %v", err)
+	}
+	var nilHandle *SMBus
+	if err := nilHandle.Bus_close(); err != nil {
+		t.Fatalf("closing a nil handle: %v", err)
+	}
+}
+
+func TestCloseAndString(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x48)
+	smb, err := NewFromPath(f.path, 0x48)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, want := smb.String(), fmt.Sprintf("SMBus(%s@0x48)", f.path); s != want {
+		t.Fatalf("String() = %q, want %q", s, want)
+	}
+	if s := fmt.Sprint(smb); s != smb.String() {
+		t.Fatalf("fmt.Sprint gave %q, not the String method", s)
+	}
+
+	var c io.Closer = smb
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := openFiles(t, f.path); n != 0 {
+		t.Fatalf("Close left %d bus files open", n)
+	}
+	if err := smb.Close(); err != nil {
+		t.Fatalf("second Close: %v", err)
+	}
+	if s := smb.String(); s != "SMBus(closed)" {
+		t.Fatalf("closed handle describes itself as %q", s)
+	}
+	var nilHandle *SMBus
+	if s := nilHandle.String(); s != "SMBus(closed)" {
+		t.Fatalf("nil handle describes itself as %q", s)
+	}
+}
+
+func TestNewFromPathClosesOnBadAddress(t *testing.T) {
+	f := newFakeBus(t)
+	f.busy[0x48] = true
+	if _, err := NewFromPath(f.path, 0x48); err == nil {
+		t.Fatal("selecting an address claimed by a driver succeeded")
+	}
+	if n := openFiles(t, f.path); n != 0 {
+		t.Fatalf("%d bus files left open", n)
+	}
+}
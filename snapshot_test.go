@@ -0,0 +1,89 @@
+package smbus
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	copy(d.regs[0x10:], []byte{0x11, 0x22, 0x33, 0x44})
+	smb := f.open(t, 0x48)
+
+	var snap bytes.Buffer
+	if err := smb.Export(&snap, 0x10, 4); err != nil {
+		t.Fatal(err)
+	}
+	for cmd := byte(0x10); cmd < 0x14; cmd++ {
+		f.setReg(0x48, cmd, 0)
+	}
+	if err := smb.Import(&snap, 0x12); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x11, 0x22, 0x00, 0x44}
+	for i, v := range want {
+		if got := f.reg(0x48, 0x10+byte(i)); got != v {
+			t.Fatalf("register 0x%02X holds 0x%02X, want 0x%02X", 0x10+i, got, v)
+		}
+	}
+	if n := f.count("write_byte_data 0x48 0x12"); n != 0 {
+		t.Fatal("the skipped register was written")
+	}
+}
+
+func TestReadRangeChecksummed(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	copy(d.regs[0x20:], []byte{0x01, 0x02, 0x04})
+	smb := f.open(t, 0x48)
+
+	xor := func(b []byte) byte {
+		var s byte
+		for _, v := range b {
+			s ^= v
+		}
+		return s
+	}
+	data, sum, err := smb.ReadRangeChecksummed(0x20, 3, xor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, []byte{0x01, 0x02, 0x04}) {
+		t.Fatalf("read % X", data)
+	}
+	if sum != 0x07 || sum != xor(data) {
+		t.Fatalf("sum 0x%02X does not match the data", sum)
+	}
+}
+
+func TestVerifyAgainst(t *testing.T) {
+	f := newFakeBus(t)
+	d := f.add(0x48)
+	copy(d.regs[0x40:], []byte{0x01, 0x02, 0x03, 0x04, 0x05})
+	smb := f.open(t, 0x48)
+
+	var golden bytes.Buffer
+	if err := smb.Export(&golden, 0x40, 5); err != nil {
+		t.Fatal(err)
+	}
+	snap := golden.Bytes()
+	diffs, err := smb.VerifyAgainst(bytes.NewReader(snap))
+	if err != nil || len(diffs) != 0 {
+		t.Fatalf("got %v, %v against its own snapshot", diffs, err)
+	}
+
+	f.setReg(0x48, 0x41, 0x20)
+	f.setReg(0x48, 0x44, 0x50)
+	diffs, err = smb.VerifyAgainst(bytes.NewReader(snap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RegDiff{{0x41, 0x02, 0x20}, {0x44, 0x05, 0x50}}
+	if len(diffs) != 2 || diffs[0] != want[0] || diffs[1] != want[1] {
+		t.Fatalf("got %+v, want %+v", diffs, want)
+	}
+	if _, err := smb.VerifyAgainst(bytes.NewReader([]byte("not a snapshot"))); err == nil {
+		t.Fatal("a file without the snapshot tag was accepted")
+	}
+}
@@ -0,0 +1,111 @@
+package smbus
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Root of the sysfs tree used for adapter discovery
+var sysfsRoot = "/sys"
+
+// An i2c adapter as listed under /sys/class/i2c-dev
+type adapter struct {
+	bus  uint
+	name string
+}
+
+// Lists the i2c adapters that have a character device, ordered by bus index
+func adapters() ([]adapter, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysfsRoot, "class", "i2c-dev", "i2c-*"))
+	if err != nil {
+		return nil, err
+	}
+	var list []adapter
+	for _, dir := range dirs {
+		bus, err := strconv.ParseUint(strings.TrimPrefix(filepath.Base(dir), "i2c-"), 10, 0)
+		if err != nil {
+			continue
+		}
+		name, err := os.ReadFile(filepath.Join(dir, "name"))
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, adapter{bus: uint(bus), name: strings.TrimSpace(string(name))})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].bus < list[j].bus })
+	return list, nil
+}
+
+// Opens a handle to addr on every bus whose adapter name, as reported by
+// sysfs, contains name. If opening any of them fails, the handles opened so
+// far are closed again and the error is returned.
+func OpenByAdapterName(name string, addr byte) ([]*SMBus, error) {
+	list, err := adapters()
+	if err != nil {
+		return nil, err
+	}
+	var handles []*SMBus
+	for _, a := range list {
+		if !strings.Contains(a.name, name) {
+			continue
+		}
+		smb, err := New(a.bus, addr)
+		if err != nil {
+			for _, h := range handles {
+				h.Bus_close()
+			}
+			return nil, err
+		}
+		handles = append(handles, smb)
+	}
+	if len(handles) == 0 {
+		return nil, fmt.Errorf("No i2c adapter matches %q", name)
+	}
+	return handles, nil
+}
+
+// Returns the firmware paths identifying the bus at dir, a directory under
+// /sys/bus/i2c/devices: its device tree node path, such as
+// "/soc/i2c@7e804000", and its ACPI path, such as "\_SB_.PCI0.I2C0". Both
+// are looked up on the adapter and on its parent controller device.
+func firmwarePaths(dir string) []string {
+	var paths []string
+	dtBase := filepath.Join(sysfsRoot, "firmware", "devicetree", "base")
+	for _, sub := range []string{"", "device"} {
+		if node, err := filepath.EvalSymlinks(filepath.Join(dir, sub, "of_node")); err == nil {
+			if rel, err := filepath.Rel(dtBase, node); err == nil && !strings.HasPrefix(rel, "..") {
+				paths = append(paths, "/"+filepath.ToSlash(rel))
+			}
+		}
+		if acpi, err := os.ReadFile(filepath.Join(dir, sub, "firmware_node", "path")); err == nil {
+			paths = append(paths, strings.TrimSpace(string(acpi)))
+		}
+	}
+	return paths
+}
+
+// Opens a handle to addr on the bus whose controller has the device tree
+// path or ACPI path ofPath. Unlike bus numbers, these paths do not change
+// between boots.
+func OpenByDevicePath(ofPath string, addr byte) (*SMBus, error) {
+	dirs, err := filepath.Glob(filepath.Join(sysfsRoot, "bus", "i2c", "devices", "i2c-*"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		bus, err := strconv.ParseUint(strings.TrimPrefix(filepath.Base(dir), "i2c-"), 10, 0)
+		if err != nil {
+			continue
+		}
+		for _, p := range firmwarePaths(dir) {
+			if p == ofPath {
+				return New(uint(bus), addr)
+			}
+		}
+	}
+	return nil, fmt.Errorf("No i2c bus has the firmware path %q", ofPath)
+}
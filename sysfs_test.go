@@ -0,0 +1,101 @@
+package smbus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Points sysfsRoot at an empty temporary tree for the test and returns it
+func fakeSysfs(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	prev := sysfsRoot
+	sysfsRoot = root
+	t.Cleanup(func() { sysfsRoot = prev })
+	return root
+}
+
+// Creates the file at path below root, with its parent directories
+func writeFile(t *testing.T, root, path, content string) {
+	t.Helper()
+	path = filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenByAdapterName(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x50)
+	f.addBus(t, 2)
+	f.addBus(t, 3)
+	root := fakeSysfs(t)
+	writeFile(t, root, "class/i2c-dev/i2c-1/name", "SMBus I801 adapter at f040\n")
+	writeFile(t, root, "class/i2c-dev/i2c-2/name", "i915 gmbus dpb\n")
+	writeFile(t, root, "class/i2c-dev/i2c-3/name", "SMBus I801 adapter at 5000\n")
+
+	handles, err := OpenByAdapterName("I801", 0x50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		for _, h := range handles {
+			h.Bus_close()
+		}
+	}()
+	if len(handles) != 2 {
+		t.Fatalf("opened %d buses, want 2", len(handles))
+	}
+	dir := filepath.Dir(f.path)
+	for i, want := range []string{"i2c-1", "i2c-3"} {
+		if handles[i].path != filepath.Join(dir, want) {
+			t.Fatalf("handle %d is on %s, want %s", i, handles[i].path, want)
+		}
+	}
+	if _, err := OpenByAdapterName("nvidia", 0x50); err == nil {
+		t.Fatal("a name no adapter has matched")
+	}
+}
+
+func TestOpenByDevicePath(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x50)
+	f.addBus(t, 2)
+	root := fakeSysfs(t)
+	devices := filepath.Join(root, "bus", "i2c", "devices")
+	// bus 1 has a device tree node, bus 2 an ACPI node on its controller
+	node := filepath.Join(root, "firmware", "devicetree", "base", "soc", "i2c@7e804000")
+	if err := os.MkdirAll(node, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(devices, "i2c-1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(node, filepath.Join(devices, "i2c-1", "of_node")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, devices, "i2c-2/device/firmware_node/path", "\\_SB_.PCI0.I2C0\n")
+
+	dir := filepath.Dir(f.path)
+	for _, tc := range []struct{ path, bus string }{
+		{"/soc/i2c@7e804000", "i2c-1"},
+		{"\\_SB_.PCI0.I2C0", "i2c-2"},
+	} {
+		smb, err := OpenByDevicePath(tc.path, 0x50)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.path, err)
+		}
+		got := smb.path
+		smb.Bus_close()
+		if got != filepath.Join(dir, tc.bus) {
+			t.Fatalf("%s opened %s, want %s", tc.path, got, tc.bus)
+		}
+	}
+	if _, err := OpenByDevicePath("/soc/i2c@7e805000", 0x50); err == nil {
+		t.Fatal("a path no bus has matched")
+	}
+}
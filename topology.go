@@ -0,0 +1,155 @@
+package smbus
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// What a scan found on a bus
+type Topology struct {
+	// adapter name as reported by sysfs, empty if unavailable
+	Adapter string
+	// adapter functionality mask from the I2C_FUNCS ioctl
+	Funcs uint64
+	// 7-bit addresses that acknowledged a Receive Byte or are claimed by a
+	// kernel driver, in ascending order
+	Devices []byte
+	// when the scan ran
+	Scanned time.Time
+}
+
+// Returns the topology of the bus, scanning it on the first call and
+// serving the cached result afterwards. The scan is ScanWith(ProbeRead).
+func (smb *SMBus) BuildTopology() (Topology, error) {
+	smb.topoMu.Lock()
+	defer smb.topoMu.Unlock()
+	if smb.topo != nil {
+		return smb.topo.clone(), nil
+	}
+	return smb.scanTopology()
+}
+
+// Scans the bus again and replaces the cached topology
+func (smb *SMBus) RefreshTopology() (Topology, error) {
+	smb.topoMu.Lock()
+	defer smb.topoMu.Unlock()
+	return smb.scanTopology()
+}
+
+// Returns the cached topology without touching the bus. The zero Topology
+// is returned if BuildTopology has not succeeded yet.
+func (smb *SMBus) Topology() Topology {
+	smb.topoMu.Lock()
+	defer smb.topoMu.Unlock()
+	if smb.topo == nil {
+		return Topology{}
+	}
+	return smb.topo.clone()
+}
+
+// Copies t so callers cannot modify the cached device list
+func (t *Topology) clone() Topology {
+	c := *t
+	c.Devices = append([]byte(nil), t.Devices...)
+	return c
+}
+
+// Scans the bus and caches the result. The caller must hold topoMu.
+func (smb *SMBus) scanTopology() (Topology, error) {
+	f, err := smb.funcs()
+	if err != nil {
+		return Topology{}, err
+	}
+	devices, err := smb.ScanWith(ProbeRead)
+	if err != nil {
+		return Topology{}, err
+	}
+	t := Topology{Adapter: smb.adapterName(), Funcs: f, Devices: devices}
+	t.Scanned = time.Now()
+	smb.topo = &t
+	return t.clone(), nil
+}
+
+// Returns the sysfs name of the handle's adapter, or "" if it cannot be read
+func (smb *SMBus) adapterName() string {
+	name, err := os.ReadFile(filepath.Join(sysfsRoot, "class", "i2c-dev", filepath.Base(smb.path), "name"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(name))
+}
+
+// How Scan probes an address
+type ProbeMode int
+
+const (
+	// Receive Byte for 0x30-0x37 and 0x50-0x5F, where a quick write can
+	// corrupt EEPROMs or lock up some chips, and quick write elsewhere, as
+	// i2cdetect does
+	ProbeAuto ProbeMode = iota
+	// SMBus Quick Command (write) for every address
+	ProbeQuick
+	// SMBus Receive Byte for every address
+	ProbeRead
+)
+
+// Same as ScanWith(ProbeAuto)
+func (smb *SMBus) Scan() ([]byte, error) {
+	return smb.ScanWith(ProbeAuto)
+}
+
+// Probes every non-reserved 7-bit address, 0x03 to 0x77, and returns the
+// ones that acknowledged, in ascending order. The scan runs under the bus
+// lock and restores the handle's address afterwards. Addresses claimed by
+// a kernel driver are reported without being probed. In ProbeAuto mode,
+// adapters without quick command support are probed with Receive Byte
+// throughout.
+func (smb *SMBus) ScanWith(mode ProbeMode) ([]byte, error) {
+	quick := true
+	if mode == ProbeAuto {
+		f, err := smb.funcs()
+		if err != nil {
+			return nil, err
+		}
+		quick = f&FUNC_SMBUS_QUICK != 0
+	}
+	addrs := make([]byte, 0, 0x78-0x03)
+	for addr := byte(0x03); addr <= 0x77; addr++ {
+		addrs = append(addrs, addr)
+	}
+	defer smb.lock()()
+	return smb.probeAddrs(addrs, func(addr byte) error {
+		if mode == ProbeQuick ||
+			mode == ProbeAuto && quick && !(addr >= 0x30 && addr <= 0x37) && !(addr >= 0x50 && addr <= 0x5F) {
+			return smb.writeQuick(0)
+		}
+		_, err := smb.readByte()
+		return err
+	})
+}
+
+// Selects each of addrs in turn and calls probe for it, then restores the
+// handle's address. Returns the addresses where probe succeeded, plus those
+// claimed by a kernel driver, which are not probed. The caller must hold
+// the bus lock.
+func (smb *SMBus) probeAddrs(addrs []byte, probe func(addr byte) error) ([]byte, error) {
+	restore := smb.saveAddr()
+	var found []byte
+	for _, addr := range addrs {
+		if err := smb.setAddr(addr); errors.Is(err, syscall.EBUSY) {
+			found = append(found, addr)
+			continue
+		} else if err != nil {
+			restore()
+			return nil, err
+		}
+		if probe(addr) == nil {
+			found = append(found, addr)
+		}
+	}
+	return found, restore()
+}
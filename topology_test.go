@@ -0,0 +1,99 @@
+package smbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildTopologyCachesScan(t *testing.T) {
+	f := newFakeBus(t)
+	f.add(0x20)
+	f.add(0x48)
+	f.busy[0x50] = true
+	smb := f.open(t, 0x48)
+
+	want := []byte{0x20, 0x48, 0x50}
+	topo, err := smb.BuildTopology()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(topo.Devices, want) {
+		t.Fatalf("devices % X, want % X", topo.Devices, want)
+	}
+	probes := f.count("read_byte")
+	if probes == 0 {
+		t.Fatal("scan sent no Receive Byte probes")
+	}
+
+	// later queries are served from the cache
+	again, err := smb.BuildTopology()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again.Devices, want) || !smb.Topology().Scanned.Equal(topo.Scanned) {
+		t.Fatalf("cached topology %+v differs from %+v", again, topo)
+	}
+	if n := f.count("read_byte"); n != probes {
+		t.Fatalf("second BuildTopology probed the bus again: %d probes, want %d", n, probes)
+	}
+
+	// the handle's address is restored after the scan
+	f.setReg(0x48, 0x01, 0x77)
+	if v, err := smb.Read_byte_data(0x01); err != nil || v != 0x77 {
+		t.Fatalf("read after scan: 0x%02X, %v", v, err)
+	}
+}
+
+func TestScanProbeOps(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		mode  ProbeMode
+		quick bool // adapter supports the quick command
+		want  func(addr byte) string
+	}{
+		{"auto", ProbeAuto, true, func(addr byte) string {
+			if addr >= 0x30 && addr <= 0x37 || addr >= 0x50 && addr <= 0x5F {
+				return "read_byte"
+			}
+			return "quick"
+		}},
+		{"auto without quick", ProbeAuto, false, func(byte) string { return "read_byte" }},
+		{"quick", ProbeQuick, true, func(byte) string { return "quick" }},
+		{"read", ProbeRead, true, func(byte) string { return "read_byte" }},
+	} {
+		f := newFakeBus(t)
+		if !tc.quick {
+			f.funcMask &^= FUNC_SMBUS_QUICK
+		}
+		f.add(0x20)
+		f.add(0x36)
+		f.add(0x50)
+		f.busy[0x68] = true
+		smb := f.open(t, 0x20)
+		probed := make(map[byte]string)
+		f.fail = func(op string, addr uint16, cmd byte) error {
+			if op == "quick" || op == "read_byte" {
+				probed[byte(addr)] = op
+			}
+			return nil
+		}
+
+		found, err := smb.ScanWith(tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []byte{0x20, 0x36, 0x50, 0x68}; !reflect.DeepEqual(found, want) {
+			t.Fatalf("%s: found % X, want % X", tc.name, found, want)
+		}
+		for addr := byte(0x03); addr <= 0x77; addr++ {
+			want := tc.want(addr)
+			if addr == 0x68 {
+				// claimed by a driver, reported without a probe
+				want = ""
+			}
+			if got := probed[addr]; got != want {
+				t.Errorf("%s: address 0x%02X probed with %q, want %q", tc.name, addr, got, want)
+			}
+		}
+	}
+}
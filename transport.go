@@ -0,0 +1,132 @@
+package smbus
+
+/*
+#include "i2c-dev.h"
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// Read/write markers and transaction sizes of the I2C_SMBUS ioctl
+const (
+	i2c_SMBUS_WRITE = 0
+	i2c_SMBUS_READ  = 1
+
+	i2c_SMBUS_QUICK            = 0
+	i2c_SMBUS_BYTE             = 1
+	i2c_SMBUS_BYTE_DATA        = 2
+	i2c_SMBUS_WORD_DATA        = 3
+	i2c_SMBUS_PROC_CALL        = 4
+	i2c_SMBUS_BLOCK_DATA       = 5
+	i2c_SMBUS_I2C_BLOCK_BROKEN = 6
+	i2c_SMBUS_BLOCK_PROC_CALL  = 7
+	i2c_SMBUS_I2C_BLOCK_DATA   = 8
+)
+
+// Carries the ioctls of a handle to the adapter. Every transfer and ioctl
+// of the package goes through one, which lets tests put simulated devices
+// behind a handle.
+type transport interface {
+	// Issues an ioctl taking an integer argument, such as I2C_SLAVE
+	ioctl(fd, req, arg uintptr) error
+	// Returns the adapter functionality mask (I2C_FUNCS)
+	funcs(fd uintptr) (uint64, error)
+	// Performs one SMBus transaction (I2C_SMBUS). data may be nil for
+	// transactions without data.
+	smbus(fd uintptr, readWrite, cmd byte, size int, data *smbusData) error
+	// Submits msgs as one combined transaction (I2C_RDWR) and returns the
+	// number of messages the adapter processed
+	rdwr(fd uintptr, msgs []i2cMsg) (int, error)
+}
+
+// Transport of handles opened from now on
+var defaultTransport transport = kernel{}
+
+// Laid out like union i2c_smbus_data: a byte or a word in host byte order
+// at the start, or a block with its length in the first byte.
+type smbusData [C.I2C_SMBUS_BLOCK_MAX + 2]byte
+
+func (d *smbusData) word() uint16 {
+	return binary.NativeEndian.Uint16(d[:2])
+}
+
+func (d *smbusData) setWord(w uint16) {
+	binary.NativeEndian.PutUint16(d[:2], w)
+}
+
+// Returns the block held in d
+func (d *smbusData) block() []byte {
+	n := int(d[0])
+	if n > C.I2C_SMBUS_BLOCK_MAX {
+		n = C.I2C_SMBUS_BLOCK_MAX
+	}
+	return d[1 : 1+n]
+}
+
+// Stores buf as the block held in d
+func (d *smbusData) setBlock(buf []byte) {
+	d[0] = byte(copy(d[1:1+C.I2C_SMBUS_BLOCK_MAX], buf))
+}
+
+// One message of an I2C_RDWR transaction, reading into or writing from buf
+type i2cMsg struct {
+	addr  uint16
+	flags uint16
+	buf   []byte
+}
+
+// Passes everything to the kernel i2c-dev driver
+type kernel struct{}
+
+func (kernel) ioctl(fd, req, arg uintptr) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_IOCTL, fd, req, arg, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (kernel) funcs(fd uintptr) (uint64, error) {
+	var f C.ulong
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, i2c_FUNCS, uintptr(unsafe.Pointer(&f)))
+	if errno != 0 {
+		return 0, errno
+	}
+	return uint64(f), nil
+}
+
+func (kernel) smbus(fd uintptr, readWrite, cmd byte, size int, data *smbusData) error {
+	ret, err := C.i2c_smbus_access(C.int(fd), C.char(readWrite), C.__u8(cmd), C.int(size), (*C.union_i2c_smbus_data)(unsafe.Pointer(data)))
+	if ret < 0 {
+		return err
+	}
+	return nil
+}
+
+func (kernel) rdwr(fd uintptr, msgs []i2cMsg) (int, error) {
+	cmsgs := make([]C.struct_i2c_msg, len(msgs))
+	for i, m := range msgs {
+		cmsgs[i] = C.struct_i2c_msg{
+			addr:  C.__u16(m.addr),
+			flags: C.ushort(m.flags),
+			len:   C.short(len(m.buf)),
+			buf:   (*C.char)(unsafe.Pointer(&m.buf[0])),
+		}
+	}
+	data := C.struct_i2c_rdwr_ioctl_data{
+		msgs:  &cmsgs[0],
+		nmsgs: C.__u32(len(cmsgs)),
+	}
+	n, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, i2c_RDWR, uintptr(unsafe.Pointer(&data)))
+	runtime.KeepAlive(cmsgs)
+	runtime.KeepAlive(msgs)
+	if errno != 0 {
+		return 0, errno
+	}
+	return int(n), nil
+}